@@ -0,0 +1,230 @@
+/*===============================================================*/
+/* The SPS Bot (AI opponents)                                    */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const AI_NONE = 0
+const AI_RANDOM = 1
+const AI_FREQUENCY = 2
+const AI_MARKOV = 3
+const AI_STRATEGY_CNT = 4
+
+const AI_CHAT_ID = 0
+
+// the delay before the AI player makes the move
+const AI_THINK_TIME = time.Second
+
+func AIStrategyToStr(strategy int, locale *LanguageStrings) string {
+	switch strategy {
+	case AI_FREQUENCY:
+		return locale.AIFrequency
+	case AI_MARKOV:
+		return locale.AIMarkov
+	default:
+		return locale.AIRandom
+	}
+}
+
+func aiStrategyName(strategy int) string {
+	switch strategy {
+	case AI_FREQUENCY:
+		return "frequency"
+	case AI_MARKOV:
+		return "markov"
+	default:
+		return "random"
+	}
+}
+
+/* AI strategies */
+
+func aiRandomMove(rules *GameRules) int {
+	return rules.Gestures[rand.IntN(len(rules.Gestures))].Choose
+}
+
+// aiCounterMove returns the random gesture that beats the predicted one
+func aiCounterMove(rules *GameRules, predicted int) int {
+	counters := make([]int, 0)
+	for _, gesture := range rules.Gestures {
+		if gesture.Beats&predicted != 0 {
+			counters = append(counters, gesture.Choose)
+		}
+	}
+	if len(counters) == 0 {
+		return aiRandomMove(rules)
+	}
+	return counters[rand.IntN(len(counters))]
+}
+
+func aiMostFrequent(counts map[int]int) int {
+	best, best_cnt := 0, 0
+	for choose, cnt := range counts {
+		if cnt > best_cnt || (cnt == best_cnt && choose < best) {
+			best, best_cnt = choose, cnt
+		}
+	}
+	return best
+}
+
+// aiFrequencyMove counters the gesture the humans choose most often
+func aiFrequencyMove(rules *GameRules, histories [][]int) int {
+	counts := make(map[int]int)
+	for _, chooses := range histories {
+		for _, choose := range chooses {
+			if choose != 0 {
+				counts[choose]++
+			}
+		}
+	}
+	if len(counts) == 0 {
+		return aiRandomMove(rules)
+	}
+	return aiCounterMove(rules, aiMostFrequent(counts))
+}
+
+// aiMarkovMove predicts the next human gestures with the first order
+// Markov chain built over the humans histories and counters them
+func aiMarkovMove(rules *GameRules, histories [][]int) int {
+	transitions := make(map[int]map[int]int)
+	for _, chooses := range histories {
+		for i := 1; i < len(chooses); i++ {
+			if chooses[i-1] == 0 || chooses[i] == 0 {
+				continue
+			}
+			if transitions[chooses[i-1]] == nil {
+				transitions[chooses[i-1]] = make(map[int]int)
+			}
+			transitions[chooses[i-1]][chooses[i]]++
+		}
+	}
+
+	predicted := make(map[int]int)
+	for _, chooses := range histories {
+		if len(chooses) == 0 || chooses[len(chooses)-1] == 0 {
+			continue
+		}
+		for next, cnt := range transitions[chooses[len(chooses)-1]] {
+			predicted[next] += cnt
+		}
+	}
+	if len(predicted) == 0 {
+		return aiFrequencyMove(rules, histories)
+	}
+	return aiCounterMove(rules, aiMostFrequent(predicted))
+}
+
+/* Pool AI impl */
+
+func (pool *Pool) AddAIMember(room *PoolRoom, strategy int) (*PoolClient, error) {
+	if strategy <= AI_NONE || strategy >= AI_STRATEGY_CNT {
+		return nil, ErrUnknownAI
+	}
+
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return nil, err
+	}
+	if state.State != GST_WAITING {
+		return nil, ErrAlreadyClosed
+	}
+
+	// synthetic users have the negative ids. The id is taken with the
+	// insert itself so the concurrent calls can not get the same one
+	cols, err := pool.addaiuser_stmt.DoSelectRow(
+		[]any{AI_CHAT_ID},
+		[]variantParam{USERID_COL})
+	if err != nil {
+		return nil, err
+	}
+	uid := cols[USERID_COL.name].(int64)
+
+	id := NewUserId(uid, AI_CHAT_ID)
+	name := fmt.Sprintf("ai_%s_%d", aiStrategyName(strategy), -uid)
+	_, err = pool.dbAddCID(id, name, DefaultLocale().IETFCode, name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := pool.NewPoolClient(id, name, DefaultLocale())
+	if err != nil {
+		return nil, err
+	}
+	err = pool.updateClientSettings(client, &PoolClientSettings{AI: strategy})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pool.AddMember(room, client)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (pool *Pool) GetAIStrategy(client *PoolClient) int {
+	if !client.GetID().IsAI() {
+		return AI_NONE
+	}
+	cols, err := pool.getuser_stmt.DoSelectRow(
+		[]any{client.id.user_id, client.id.chat_id},
+		[]variantParam{SETTINGS_COL})
+	if err != nil {
+		return AI_RANDOM
+	}
+	return GenClientSettings(cols[SETTINGS_COL.name].(string)).AI
+}
+
+// chooseAIMove chooses the move for the round. Only the moves of the
+// previous rounds are seen, the humans could have already voted in this one
+func (pool *Pool) chooseAIMove(room *PoolRoom, strategy int, round int, members []*PoolClient) int {
+	rules := room.GetRules()
+
+	histories := make([][]int, 0)
+	for _, mem := range members {
+		if !mem.GetID().IsAI() {
+			chooses := mem.player.Chooses
+			if len(chooses) > round-1 {
+				chooses = chooses[:round-1]
+			}
+			histories = append(histories, chooses)
+		}
+	}
+
+	switch strategy {
+	case AI_FREQUENCY:
+		return aiFrequencyMove(rules, histories)
+	case AI_MARKOV:
+		return aiMarkovMove(rules, histories)
+	default:
+		return aiRandomMove(rules)
+	}
+}
+
+func (pool *Pool) playAI(room *PoolRoom, client *PoolClient, round int) {
+	time.Sleep(AI_THINK_TIME)
+
+	mem_state, err := pool.GetMemberState(room, client)
+	if err != nil {
+		return
+	}
+
+	// the move could be already decided and committed
+	move := mem_state.Planned
+	if move == 0 {
+		members, err := pool.GetMembers(room)
+		if err != nil {
+			return
+		}
+		move = pool.chooseAIMove(room, pool.GetAIStrategy(client), round, members)
+	}
+	pool.UpdateMemberChoose(client, room, int64(round), move)
+}
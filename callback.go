@@ -0,0 +1,141 @@
+/*===============================================================*/
+/* The SPS Bot (signed callback data)                            */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// the length of the truncated HMAC in bytes. Its hex form with the
+// separator takes 17 of the 64 bytes allowed for the callback data
+const CALLBACK_SIG_LEN = 8
+
+const MAX_CALLBACK_DATA_LEN = 64
+
+var ErrCallbackTooLong error = fmt.Errorf("callback data is too long")
+
+// the secret to sign the callback data
+var callback_secret []byte
+
+// SetCallbackSecret sets the secret to sign the callback data. If the
+// secret is empty the random one is generated, so the buttons sent
+// before the restart of the bot become invalid
+func SetCallbackSecret(secret string) error {
+	if len(secret) > 0 {
+		callback_secret = []byte(secret)
+		return nil
+	}
+	callback_secret = make([]byte, 32)
+	_, err := crand.Read(callback_secret)
+	return err
+}
+
+// CallbackSignature returns the signature of the callback data sent to
+// the chat. The signature is bound to the chat so the buttons can not be
+// replayed by other users
+func CallbackSignature(chat_id int64, data string) string {
+	mac := hmac.New(sha256.New, callback_secret)
+	fmt.Fprintf(mac, "%d:%s", chat_id, data)
+	return hex.EncodeToString(mac.Sum(nil)[:CALLBACK_SIG_LEN])
+}
+
+func SignCallbackData(chat_id int64, data string) string {
+	return data + "&" + CallbackSignature(chat_id, data)
+}
+
+// VerifyCallbackData checks the signature of the callback data and
+// returns the data without the signature
+func VerifyCallbackData(chat_id int64, signed string) (string, bool) {
+	pos := strings.LastIndex(signed, "&")
+	if pos < 0 {
+		return "", false
+	}
+	data, sig := signed[:pos], signed[pos+1:]
+	if !hmac.Equal([]byte(sig), []byte(CallbackSignature(chat_id, data))) {
+		return "", false
+	}
+	return data, true
+}
+
+// signKeyboard signs the callback data of the buttons. Telegram rejects
+// the whole message if some data does not fit the limit
+func signKeyboard(chat_id int64, keyboard tgbotapi.InlineKeyboardMarkup) (tgbotapi.InlineKeyboardMarkup, error) {
+	// the keyboard is copied as it could be shared between the messages
+	signed := tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: make([][]tgbotapi.InlineKeyboardButton, len(keyboard.InlineKeyboard)),
+	}
+	for i, row := range keyboard.InlineKeyboard {
+		signed.InlineKeyboard[i] = make([]tgbotapi.InlineKeyboardButton, len(row))
+		for j, button := range row {
+			if button.CallbackData != nil {
+				data := SignCallbackData(chat_id, *button.CallbackData)
+				if len(data) > MAX_CALLBACK_DATA_LEN {
+					return keyboard, fmt.Errorf("%w: %s", ErrCallbackTooLong, data)
+				}
+				button.CallbackData = &data
+			}
+			signed.InlineKeyboard[i][j] = button
+		}
+	}
+	return signed, nil
+}
+
+// SignChattable signs the callback data of the inline keyboard attached
+// to the message
+func SignChattable(c tgbotapi.Chattable) (tgbotapi.Chattable, error) {
+	switch msg := c.(type) {
+	case tgbotapi.MessageConfig:
+		if keyboard, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+			signed, err := signKeyboard(msg.ChatID, keyboard)
+			if err != nil {
+				return c, err
+			}
+			msg.ReplyMarkup = signed
+		}
+		return msg, nil
+	case tgbotapi.EditMessageTextConfig:
+		if msg.ReplyMarkup != nil {
+			keyboard, err := signKeyboard(msg.ChatID, *msg.ReplyMarkup)
+			if err != nil {
+				return c, err
+			}
+			msg.ReplyMarkup = &keyboard
+		}
+		return msg, nil
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		if msg.ReplyMarkup != nil {
+			keyboard, err := signKeyboard(msg.ChatID, *msg.ReplyMarkup)
+			if err != nil {
+				return c, err
+			}
+			msg.ReplyMarkup = &keyboard
+		}
+		return msg, nil
+	}
+	return c, nil
+}
+
+// SendSigned signs the message and sends it. The message with the broken
+// keyboard is not sent at all
+func SendSigned(bot *tgbotapi.BotAPI, c tgbotapi.Chattable) error {
+	signed, err := SignChattable(c)
+	if err == nil {
+		_, err = bot.Request(signed)
+	}
+	if err != nil {
+		log.Printf("Send message: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+/*===============================================================*/
+/* The SPS Bot (signed callback data tests)                      */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallbackDataSignature(t *testing.T) {
+	if err := SetCallbackSecret("secret"); err != nil {
+		t.Fatal(err)
+	}
+	const chat_id = -1001234567890
+	const data = "/join&room&4"
+
+	signed := SignCallbackData(chat_id, data)
+	if len(signed) != len(data)+1+CALLBACK_SIG_LEN*2 {
+		t.Errorf("got the signed data %q of length %d", signed, len(signed))
+	}
+	if got, ok := VerifyCallbackData(chat_id, signed); !ok || got != data {
+		t.Errorf("got %q, %v, want %q, true", got, ok, data)
+	}
+
+	sig := signed[strings.LastIndex(signed, "&")+1:]
+	tests := []struct {
+		name    string
+		chat_id int64
+		signed  string
+	}{
+		{"other chat", 42, signed},
+		{"changed data", chat_id, "/join&room&5&" + sig},
+		{"changed signature", chat_id, data + "&" + strings.Repeat("0", len(sig))},
+		{"short signature", chat_id, signed[:len(signed)-1]},
+		{"no signature", chat_id, data},
+		{"no separator", chat_id, "/start"},
+		{"empty", chat_id, ""},
+	}
+	for _, test := range tests {
+		if got, ok := VerifyCallbackData(test.chat_id, test.signed); ok {
+			t.Errorf("%s: %q is accepted as %q", test.name, test.signed, got)
+		}
+	}
+
+	// the buttons signed with the old secret become invalid
+	if err := SetCallbackSecret("other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := VerifyCallbackData(chat_id, signed); ok {
+		t.Errorf("%q is accepted with the other secret", signed)
+	}
+}
+
+func TestCallbackRandomSecret(t *testing.T) {
+	if err := SetCallbackSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	first := SignCallbackData(1, "/start")
+	if err := SetCallbackSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	if second := SignCallbackData(1, "/start"); first == second {
+		t.Errorf("the random secrets give the same signature %q", first)
+	}
+}
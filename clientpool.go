@@ -7,15 +7,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/base64"
-	"encoding/binary"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand/v2"
+	"log"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -23,10 +27,21 @@ import (
 	"database/sql"
 
 	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/scrypt"
 )
 
 var ErrAlreadyClosed error = fmt.Errorf("already closed")
 var ErrNoActiveRooms error = fmt.Errorf("no active rooms")
+var ErrUnknownAI error = fmt.Errorf("unknown AI strategy")
+var ErrStaleRound error = fmt.Errorf("stale round")
+var ErrChoiceCommitted error = fmt.Errorf("the choice is already committed")
+var ErrNotInQueue error = fmt.Errorf("not in the join queue")
+var ErrNotMember error = fmt.Errorf("not a member of the room")
+var ErrOwnerHasRoom error = fmt.Errorf("the new owner already has the room with the same name")
+var ErrPasswordRequired error = fmt.Errorf("password required")
+var ErrNotEnoughPlayers error = fmt.Errorf("not enough players")
+var ErrGameInProgress error = fmt.Errorf("the game is in progress")
+var ErrRoomExists error = fmt.Errorf("the room with the same name already exists")
 
 /* TgUserId decl */
 
@@ -49,6 +64,22 @@ func (id *TgUserId) GetUserID() int64 {
 	return id.user_id
 }
 
+// IsAI checks if the id belongs to the synthetic AI member
+func (id *TgUserId) IsAI() bool {
+	return id.user_id < 0
+}
+
+// IsLobby checks if the id belongs to the owner of the matchmaking rooms
+func (id *TgUserId) IsLobby() bool {
+	return id.user_id == LOBBY_USER_ID && id.chat_id == LOBBY_CHAT_ID
+}
+
+// IsPrivateChat checks if the user is in the private chat with the bot.
+// The id of the private chat equals the id of the user
+func (id *TgUserId) IsPrivateChat() bool {
+	return id.user_id == id.chat_id
+}
+
 func (id *TgUserId) Compare(src *TgUserId) int {
 	if id.user_id < src.user_id {
 		return -1
@@ -73,6 +104,7 @@ const (
 )
 
 type PoolClientSettings struct {
+	AI int `json:"ai,omitempty"`
 }
 
 func GenClientSettings(sett string) *PoolClientSettings {
@@ -82,18 +114,295 @@ func GenClientSettings(sett string) *PoolClientSettings {
 }
 
 type PoolRoomSettings struct {
+	Rules         int          `json:"rules"`
+	Custom        *CustomRules `json:"custom,omitempty"`
+	TurnTimeout   int          `json:"turn_timeout"`
+	TimeoutPolicy int          `json:"timeout_policy"`
+	MatchWins     int          `json:"match_wins"`
+	DrawStrategy  int          `json:"draw_strategy"`
+	DrawLimit     int          `json:"draw_limit"`
+	Mode          int          `json:"mode"`
+	Rounds        int          `json:"rounds"`
+	Lives         int          `json:"lives"`
+	CommitReveal  bool         `json:"commit_reveal"`
+	OwnerLeave    int          `json:"owner_leave"`
+	PassSalt      string       `json:"pass_salt,omitempty"`
+	PassHash      string       `json:"pass_hash,omitempty"`
+	MinPlayers    int          `json:"min_players"`
+	MaxPlayers    int          `json:"max_players"`
+	AutoStart     bool         `json:"auto_start"`
+	InviteTTL     int          `json:"invite_ttl"`
+	InviteUses    int          `json:"invite_uses"`
+	// the compiled custom rules
+	rules *GameRules
+}
+
+var MIN_PLAYERS = []int{2, 3, 4, 6, 8}
+
+// 0 - unlimited
+var MAX_PLAYERS = []int{0, 2, 3, 4, 6, 8, 10}
+
+// the countdown before the game starts in the filled room
+const AUTOSTART_DELAY = 10 * time.Second
+
+// the lifetime of the invite links in hours, 0 - unlimited
+var INVITE_TTLS = []int{0, 1, 6, 24, 168}
+
+// the number of joins allowed with one invite link, 0 - unlimited
+var INVITE_USES = []int{0, 1, 5, 10, 50}
+
+func (sett *PoolRoomSettings) GetInviteTTL() int {
+	if sett == nil || sett.InviteTTL < 0 {
+		return 0
+	}
+	return sett.InviteTTL
+}
+
+func (sett *PoolRoomSettings) GetInviteUses() int {
+	if sett == nil || sett.InviteUses < 0 {
+		return 0
+	}
+	return sett.InviteUses
+}
+
+func (sett *PoolRoomSettings) GetMinPlayers() int {
+	if sett == nil || sett.MinPlayers < 2 {
+		return 2
+	}
+	return sett.MinPlayers
+}
+
+func (sett *PoolRoomSettings) GetMaxPlayers() int {
+	if sett == nil || sett.MaxPlayers < 0 {
+		return 0
+	}
+	return sett.MaxPlayers
+}
+
+// the number of attempts to enter the room password
+const MAX_PASS_ATTEMPTS = 3
+
+// the attempts counter resets after the period
+const PASS_ATTEMPTS_PERIOD = time.Hour
+
+func (sett *PoolRoomSettings) HasPassword() bool {
+	return sett != nil && len(sett.PassHash) > 0
+}
+
+// the scrypt parameters of the password hash
+const PASS_SCRYPT_N = 1 << 15
+const PASS_SCRYPT_R = 8
+const PASS_SCRYPT_P = 1
+const PASS_KEY_LEN = 32
+
+func PasswordHash(salt, pass string) (string, error) {
+	key, err := scrypt.Key([]byte(pass), []byte(salt),
+		PASS_SCRYPT_N, PASS_SCRYPT_R, PASS_SCRYPT_P, PASS_KEY_LEN)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// CheckPasswordHash compares the password with the stored hash in the
+// constant time
+func CheckPasswordHash(salt, hash, pass string) (bool, error) {
+	expected, err := PasswordHash(salt, pass)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1, nil
+}
+
+// what happens with the room when the owner leaves it
+const OWNER_LEAVE_CLOSE = 0
+const OWNER_LEAVE_TRANSFER = 1
+
+func (sett *PoolRoomSettings) GetOwnerLeave() int {
+	if sett == nil {
+		return OWNER_LEAVE_CLOSE
+	}
+	return sett.OwnerLeave
+}
+
+var PLAYER_LIVES = []int{1, 2, 3, 5}
+
+func (sett *PoolRoomSettings) GetLives() int {
+	if sett == nil || sett.Lives < 1 {
+		return 1
+	}
+	return sett.Lives
+}
+
+const MODE_ELIMINATION = 0
+const MODE_SCORING = 1
+const MODE_CNT = 2
+
+var SCORING_ROUNDS = []int{3, 5, 10}
+
+func (sett *PoolRoomSettings) GetMode() int {
+	if sett == nil {
+		return MODE_ELIMINATION
+	}
+	return sett.Mode
+}
+
+func (sett *PoolRoomSettings) GetRounds() int {
+	if sett == nil || sett.Rounds < 1 {
+		return SCORING_ROUNDS[1]
+	}
+	return sett.Rounds
+}
+
+func (sett *PoolRoomSettings) GetDrawLimit() int {
+	if sett == nil || sett.DrawLimit < 1 {
+		return DRAW_LIMITS[len(DRAW_LIMITS)/2]
+	}
+	return sett.DrawLimit
+}
+
+func (sett *PoolRoomSettings) GetMatchWins() int {
+	if sett == nil || sett.MatchWins < 1 {
+		return 1
+	}
+	return sett.MatchWins
+}
+
+// compileRules compiles the custom rules once the settings are loaded or
+// changed, so the rules are not rebuilt on every move
+func (sett *PoolRoomSettings) compileRules() {
+	sett.rules = nil
+	if sett.Rules == RULES_CUSTOM && sett.Custom != nil {
+		rules, err := sett.Custom.Compile()
+		if err == nil {
+			sett.rules = rules
+		}
+	}
+}
+
+func (sett *PoolRoomSettings) GetRules() *GameRules {
+	if sett == nil {
+		return GetRules(RULES_CLASSIC)
+	}
+	if sett.rules != nil {
+		return sett.rules
+	}
+	return GetRules(sett.Rules)
 }
 
+const TIMEOUT_ELIMINATE = 0
+const TIMEOUT_RANDOM = 1
+const TIMEOUT_SKIP = 2
+const TIMEOUT_POLICY_CNT = 3
+
+var TURN_TIMEOUTS = []int{0, 30, 60, 120, 300}
+var MATCH_WINS = []int{1, 2, 3, 5}
+
 func GenRoomSettings(sett string) *PoolRoomSettings {
 	room := &(PoolRoomSettings{})
 	json.Unmarshal([]byte(sett), room)
+	room.compileRules()
 	return room
 }
 
 type PoolPlayer struct {
-	State   int   `json:"state"`
-	Choose  int   `json:"choose"`
-	Chooses []int `json:"chooses"`
+	State   int    `json:"state"`
+	Choose  int    `json:"choose"`
+	Chooses []int  `json:"chooses"`
+	Skip    bool   `json:"skip,omitempty"`
+	Lives   int    `json:"lives,omitempty"`
+	Score   int    `json:"score,omitempty"`
+	Points  int    `json:"points,omitempty"`
+	Salt    string `json:"salt,omitempty"`
+	// the member has joined with the spectator link
+	Spectator bool   `json:"spectator,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	Planned   int    `json:"planned,omitempty"`
+	// the round the player was eliminated in
+	Eliminated int `json:"eliminated,omitempty"`
+}
+
+// the length of the room token. 16 base62 symbols carry ~95 bits of
+// entropy and leave the room for the other params of the callback data
+// (64 bytes) and the start param (64 symbols)
+const ROOM_TOKEN_LEN = 16
+
+const ROOM_TOKEN_ALPHABET = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// the version of the room tokens generated with GenRoomToken
+const ROOM_TOKEN_VER = 1
+
+// GenRoomToken generates the random room token. Only the alphanumeric
+// symbols are used because "_" and "-" are the separators in the commands
+func GenRoomToken() (string, error) {
+	// the bytes above the largest multiple of the alphabet size are
+	// discarded to keep the symbols uniformly distributed
+	const limit = 256 - 256%len(ROOM_TOKEN_ALPHABET)
+
+	token := make([]byte, 0, ROOM_TOKEN_LEN)
+	buf := make([]byte, ROOM_TOKEN_LEN*2)
+	for len(token) < ROOM_TOKEN_LEN {
+		if _, err := crand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(token) < ROOM_TOKEN_LEN {
+				token = append(token, ROOM_TOKEN_ALPHABET[int(b)%len(ROOM_TOKEN_ALPHABET)])
+			}
+		}
+	}
+	return string(token), nil
+}
+
+// the length of the salt in bytes
+const SALT_LEN = 16
+
+// GenSalt generates the random salt for the commitment
+func GenSalt() (string, error) {
+	salt := make([]byte, SALT_LEN)
+	_, err := crand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// Commitment generates the commitment for the member choice in the round.
+// The member is identified by the hex of its user id. The full hash is
+// published so the server can not find another choice with the same one
+func Commitment(round int, uid string, choose int, salt string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%d:%s", round, uid, choose, salt)))
+	return hex.EncodeToString(sum[:])
+}
+
+func CommitmentUID(id *TgUserId) string {
+	return fmt.Sprintf("%x", uint64(id.user_id))
+}
+
+// PoolReveal is the snapshot of the committed choice taken when
+// the round is finished (before the next round resets the salts)
+type PoolReveal struct {
+	Client *PoolClient
+	Round  int
+	Choose int
+	Salt   string
+	Commit string
+}
+
+func collectReveals(members []*PoolClient, round int) []PoolReveal {
+	reveals := make([]PoolReveal, 0, len(members))
+	for _, mem := range members {
+		if len(mem.player.Commit) == 0 || mem.player.Choose == 0 {
+			continue
+		}
+		reveals = append(reveals, PoolReveal{
+			Client: mem,
+			Round:  round,
+			Choose: mem.player.Choose,
+			Salt:   mem.player.Salt,
+			Commit: mem.player.Commit})
+	}
+	return reveals
 }
 
 func GenPoolPlayer(st string) *PoolPlayer {
@@ -104,9 +413,21 @@ func GenPoolPlayer(st string) *PoolPlayer {
 
 /* PoolGame decl */
 
+type PoolMatchScore struct {
+	UserID int64 `json:"uid"`
+	ChatID int64 `json:"cid"`
+	Wins   int   `json:"wins"`
+}
+
 type PoolGame struct {
-	State int `json:"state"`
-	Round int `json:"round"`
+	State    int              `json:"state"`
+	Round    int              `json:"round"`
+	Deadline int64            `json:"deadline,omitempty"`
+	Session  int              `json:"session,omitempty"`
+	Draws    int              `json:"draws,omitempty"`
+	Wins     []PoolMatchScore `json:"wins,omitempty"`
+	// the id of the session in the history
+	GameId int64 `json:"game_id,omitempty"`
 }
 
 const GST_WAITING = 0
@@ -116,12 +437,61 @@ const GST_STARTED = 2
 const PST_PLAYING = 0
 const PST_WATCHING = 1
 
+// members roles
+const MEMBER_PLAYER = 0
+const MEMBER_SPECTATOR = 1
+
+// kinds of the room hashes
+const HASH_INVITE = 0
+const HASH_SPECTATE = 1
+
+// the initial member state for the role
+func memberInitState(role int) string {
+	if role == MEMBER_SPECTATOR {
+		return "{\"state\":1,\"spectator\":true}"
+	}
+	return "{}"
+}
+
+// countPlayers returns the number of members that are not spectators
+func countPlayers(members []*PoolClient) int {
+	cnt := 0
+	for _, mem := range members {
+		if !mem.player.Spectator {
+			cnt++
+		}
+	}
+	return cnt
+}
+
 func GenPoolGame(st string) *PoolGame {
 	game := &(PoolGame{})
 	json.Unmarshal([]byte(st), game)
 	return game
 }
 
+/* PoolGame impl */
+
+func (game *PoolGame) GetWins(id *TgUserId) int {
+	for _, score := range game.Wins {
+		if score.UserID == id.user_id && score.ChatID == id.chat_id {
+			return score.Wins
+		}
+	}
+	return 0
+}
+
+func (game *PoolGame) AddWin(id *TgUserId) int {
+	for i := range game.Wins {
+		if game.Wins[i].UserID == id.user_id && game.Wins[i].ChatID == id.chat_id {
+			game.Wins[i].Wins++
+			return game.Wins[i].Wins
+		}
+	}
+	game.Wins = append(game.Wins, PoolMatchScore{UserID: id.user_id, ChatID: id.chat_id, Wins: 1})
+	return 1
+}
+
 /* PoolRoom decl */
 type PoolRoom struct {
 	ownername string
@@ -143,6 +513,30 @@ func ThrowNotValidRoom(local *LanguageStrings) error {
 	return errors.New(local.NotValidRoom)
 }
 
+func ThrowBanned(local *LanguageStrings) error {
+	return errors.New(local.YouAreBanned)
+}
+
+func ThrowWrongPassword(local *LanguageStrings, left int) error {
+	return fmt.Errorf(local.WrongPassword, left)
+}
+
+func ThrowTooManyAttempts(local *LanguageStrings) error {
+	return errors.New(local.TooManyAttempts)
+}
+
+func ThrowInviteExpired(local *LanguageStrings) error {
+	return errors.New(local.InviteExpired)
+}
+
+func ThrowInviteRevoked(local *LanguageStrings) error {
+	return errors.New(local.InviteRevoked)
+}
+
+func ThrowRoomFull(local *LanguageStrings) error {
+	return errors.New(local.RoomFull)
+}
+
 func ThrowRoomClosed(local *LanguageStrings) error {
 	return errors.New(local.RoomClosed)
 }
@@ -173,10 +567,18 @@ func (room *PoolRoom) Locate(id TgUserId, name string) bool {
 	return false
 }
 
+func (room *PoolRoom) key() string {
+	return fmt.Sprintf("%d:%d:%s", room.ownerid.user_id, room.ownerid.chat_id, room.name)
+}
+
 func (room *PoolRoom) GetRoomSettings() *PoolRoomSettings {
 	return room.setts
 }
 
+func (room *PoolRoom) GetRules() *GameRules {
+	return room.setts.GetRules()
+}
+
 func (room *PoolRoom) GetGame() *PoolGame {
 	return room.state
 }
@@ -322,6 +724,26 @@ func (stmt *StmtWrapper) DoUpdate(bindings []any) error {
 	return nil
 }
 
+// DoInsert executes the insert and returns the id of the new row
+func (stmt *StmtWrapper) DoInsert(bindings []any) (int64, error) {
+
+	res, err := stmt.stmt.Exec(bindings...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+func (stmt *StmtWrapper) DoUpdateTx(tx *sql.Tx, bindings []any) error {
+
+	if _, err := tx.Stmt(stmt.stmt).Exec(bindings...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type variantParam struct {
 	name string
 	kind reflect.Kind
@@ -450,6 +872,21 @@ const (
 	UPD_WAIT_FOR_TURN
 	UPD_SESSION_FINISHED
 	UPD_CLIENT_CLOSE_ROOM
+	UPD_TURN_WARNING
+	UPD_MATCH_FINISHED
+	UPD_CHOICE_COMMITTED
+	UPD_JOIN_QUEUED
+	UPD_MEMBER_QUEUED
+	UPD_QUEUE_SESSION_FINISHED
+	UPD_JOIN_DROPPED
+	UPD_OWNER_CHANGED
+	UPD_KICKED
+	UPD_AUTOSTART
+	UPD_AUTOSTART_FAILED
+	UPD_ROOM_IDLE
+	UPD_MATCH_FOUND
+	UPD_LOBBY_EXPIRED
+	UPD_LOBBY_FINISHED
 )
 
 type PoolUpdate struct {
@@ -473,43 +910,132 @@ func (upd *PoolUpdate) GetInt(ind int) int64 {
 	return upd.Params[ind].(int64)
 }
 
+func (upd *PoolUpdate) GetPoolGame(ind int) *PoolGame {
+	return upd.Params[ind].(*PoolGame)
+}
+
+func (upd *PoolUpdate) GetReveals(ind int) []PoolReveal {
+	return upd.Params[ind].([]PoolReveal)
+}
+
+func (upd *PoolUpdate) GetError(ind int) error {
+	return upd.Params[ind].(error)
+}
+
+func (upd *PoolUpdate) GetRatingChanges(ind int) map[TgUserId]PoolRatingChange {
+	return upd.Params[ind].(map[TgUserId]PoolRatingChange)
+}
+
 type PoolUpdates chan PoolUpdate
 
 type Pool struct {
 	choose_mux sync.Mutex
 
+	timers_mux sync.Mutex
+	timers     map[string][]*time.Timer
+	autostart  map[string]*time.Timer
+
+	pass_mux sync.Mutex
+
+	janitor_cfg  PoolJanitorConfig
+	janitor_once sync.Once
+
+	lobby_mux sync.Mutex
+
 	client_db *sql.DB
 	// Prepares
-	adduser_stmt        *StmtWrapper
-	getuser_stmt        *StmtWrapper
-	upduser_stmt        *StmtWrapper
-	incuserstatt_stmt   *StmtWrapper
-	incuserstatw_stmt   *StmtWrapper
-	getuserstat_stmt    *StmtWrapper
-	addroom_stmt        *StmtWrapper
-	getroom_stmt        *StmtWrapper
-	getroomsetts_stmt   *StmtWrapper
-	getroomstate_stmt   *StmtWrapper
-	updroomsetts_stmt   *StmtWrapper
-	updroomstate_stmt   *StmtWrapper
-	getroombyhash_stmt  *StmtWrapper
-	findroombyhash_stmt *StmtWrapper
-	getroombycid_stmt   *StmtWrapper
-	addroomhash_stmt    *StmtWrapper
-	getroomhash_stmt    *StmtWrapper
-	addmember_stmt      *StmtWrapper
-	rmvmember_stmt      *StmtWrapper
-	clrmembers_stmt     *StmtWrapper
-	resetmemberst_stmt  *StmtWrapper
-	getmembers_stmt     *StmtWrapper
-	getmemberids_stmt   *StmtWrapper
-	getmember_stmt      *StmtWrapper
-	updmemberstate_stmt *StmtWrapper
+	adduser_stmt         *StmtWrapper
+	getuser_stmt         *StmtWrapper
+	upduser_stmt         *StmtWrapper
+	incuserstatt_stmt    *StmtWrapper
+	incuserstatw_stmt    *StmtWrapper
+	incuserstatm_stmt    *StmtWrapper
+	getuserstat_stmt     *StmtWrapper
+	getuserrating_stmt   *StmtWrapper
+	upduserrating_stmt   *StmtWrapper
+	addaiuser_stmt       *StmtWrapper
+	clraiusers_stmt      *StmtWrapper
+	addroom_stmt         *StmtWrapper
+	getroom_stmt         *StmtWrapper
+	getroomsetts_stmt    *StmtWrapper
+	getroomstate_stmt    *StmtWrapper
+	updroomsetts_stmt    *StmtWrapper
+	updroomstate_stmt    *StmtWrapper
+	getroombyhash_stmt   *StmtWrapper
+	findroombyhash_stmt  *StmtWrapper
+	getroombycid_stmt    *StmtWrapper
+	addroomhash_stmt     *StmtWrapper
+	getroomhash_stmt     *StmtWrapper
+	revokehash_stmt      *StmtWrapper
+	usehash_stmt         *StmtWrapper
+	addmember_stmt       *StmtWrapper
+	rmvmember_stmt       *StmtWrapper
+	clrmembers_stmt      *StmtWrapper
+	resetmemberst_stmt   *StmtWrapper
+	getmembers_stmt      *StmtWrapper
+	getmemberids_stmt    *StmtWrapper
+	getmember_stmt       *StmtWrapper
+	updmemberstate_stmt  *StmtWrapper
+	addpending_stmt      *StmtWrapper
+	rmvpending_stmt      *StmtWrapper
+	clrpending_stmt      *StmtWrapper
+	getpending_stmt      *StmtWrapper
+	getpendingmem_stmt   *StmtWrapper
+	countroom_stmt       *StmtWrapper
+	getheir_stmt         *StmtWrapper
+	xferroom_stmt        *StmtWrapper
+	xferhashes_stmt      *StmtWrapper
+	xfermembers_stmt     *StmtWrapper
+	xferpending_stmt     *StmtWrapper
+	xferbans_stmt        *StmtWrapper
+	xferplayers_stmt     *StmtWrapper
+	getownedrooms_stmt   *StmtWrapper
+	delroom_stmt         *StmtWrapper
+	delhashes_stmt       *StmtWrapper
+	delbans_stmt         *StmtWrapper
+	delplayers_stmt      *StmtWrapper
+	addplayer_stmt       *StmtWrapper
+	gettop_stmt          *StmtWrapper
+	addgame_stmt         *StmtWrapper
+	finishgame_stmt      *StmtWrapper
+	addround_stmt        *StmtWrapper
+	finishround_stmt     *StmtWrapper
+	addmove_stmt         *StmtWrapper
+	elimmove_stmt        *StmtWrapper
+	wonmove_stmt         *StmtWrapper
+	gethistory_stmt      *StmtWrapper
+	getgamerecord_stmt   *StmtWrapper
+	getroundrecords_stmt *StmtWrapper
+	getmoverecords_stmt  *StmtWrapper
+	getgesturefreqs_stmt *StmtWrapper
+	getexportmoves_stmt  *StmtWrapper
+	getchattop_stmt      *StmtWrapper
+	getroomtop_stmt      *StmtWrapper
+	getidlerooms_stmt    *StmtWrapper
+	getstartedrooms_stmt *StmtWrapper
+	clrorphans_stmts     []*StmtWrapper
+	clrrevoked_stmt      *StmtWrapper
+	addlobby_stmt        *StmtWrapper
+	rmvlobby_stmt        *StmtWrapper
+	getlobbyentry_stmt   *StmtWrapper
+	getlobby_stmt        *StmtWrapper
+	getlobbyexp_stmt     *StmtWrapper
+	addban_stmt          *StmtWrapper
+	rmvban_stmt          *StmtWrapper
+	getbans_stmt         *StmtWrapper
+	isbanned_stmt        *StmtWrapper
+	getpassattempt_stmt  *StmtWrapper
+	addpassattempt_stmt  *StmtWrapper
+	rmvpassattempt_stmt  *StmtWrapper
+	clrpassattempts_stmt *StmtWrapper
+	xferpassattempt_stmt *StmtWrapper
+	exppassattempts_stmt *StmtWrapper
 
 	updates PoolUpdates
 }
 
 var SETTINGS_COL = variantParam{"settings", reflect.String}
+var USERID_COL = variantParam{"user_id", reflect.Int}
 var STATE_COL = variantParam{"state", reflect.String}
 var HASH_COL = variantParam{"hash", reflect.String}
 var NAME_COL = variantParam{"name", reflect.String}
@@ -519,13 +1045,86 @@ var MUID_COL = variantParam{"muid", reflect.Int}
 var MCID_COL = variantParam{"mcid", reflect.Int}
 var STAT_TOTAL_COL = variantParam{"stat_total", reflect.Int}
 var STAT_WON_COL = variantParam{"stat_won", reflect.Int}
+var STAT_MATCH_WON_COL = variantParam{"stat_match_won", reflect.Int}
+var RATING_COL = variantParam{"rating", reflect.Float64}
+var RATING_RD_COL = variantParam{"rating_rd", reflect.Float64}
+var RATING_VOL_COL = variantParam{"rating_vol", reflect.Float64}
 var USERNAME_COL = variantParam{"user_name", reflect.String}
 var ROOMNAME_COL = variantParam{"roomname", reflect.String}
 var LOCALE_COL = variantParam{"locale", reflect.String}
 var CNT_COL = variantParam{"cnt", reflect.Int}
+var KIND_COL = variantParam{"kind", reflect.Int}
+var USES_COL = variantParam{"uses", reflect.Int}
+var REVOKED_COL = variantParam{"revoked", reflect.Int}
+var AGE_COL = variantParam{"age", reflect.Int}
+var LAST_USED_COL = variantParam{"last_used", reflect.String}
+var PLAYERS_COL = variantParam{"players", reflect.Int}
+var WINRATE_COL = variantParam{"winrate", reflect.Int}
+var ID_COL = variantParam{"id", reflect.Int}
+var GAME_ID_COL = variantParam{"game_id", reflect.Int}
+var ROUND_COL = variantParam{"round", reflect.Int}
+var ROUNDS_COL = variantParam{"rounds", reflect.Int}
+var WINNER_SIGNS_COL = variantParam{"winner_signs", reflect.String}
+var DRAW_COL = variantParam{"draw", reflect.Int}
+var SIGN_COL = variantParam{"sign", reflect.String}
+var SKIP_COL = variantParam{"skip", reflect.Int}
+var ELIMINATED_COL = variantParam{"eliminated", reflect.Int}
+var WON_COL = variantParam{"won", reflect.Int}
+var STARTED_AT_COL = variantParam{"started_at", reflect.String}
+var FINISHED_AT_COL = variantParam{"finished_at", reflect.String}
 
 /* Pool impl */
 
+// rotateRoomTokens replaces the room tokens generated with the outdated
+// algorithm. The old tokens were derived from the owner ids and the
+// current time so they could be guessed
+func rotateRoomTokens(db *sql.DB) error {
+	rows, err := db.Query("select \"rowid\" from \"rooms_hashes\" "+
+		"where coalesce(\"token_ver\", 0)<?1;", ROOM_TOKEN_VER)
+	if err != nil {
+		return err
+	}
+	rowids := make([]int64, 0)
+	for rows.Next() {
+		var rowid int64
+		if err = rows.Scan(&rowid); err != nil {
+			rows.Close()
+			return err
+		}
+		rowids = append(rowids, rowid)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rowid := range rowids {
+		token, err := GenRoomToken()
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("update \"rooms_hashes\" set \"hash\"=?1, \"token_ver\"=?2 "+
+			"where \"rowid\"==?3;", token, ROOM_TOKEN_VER, rowid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addColumnIfNotExists(db *sql.DB, table, column, decl string) error {
+	var cnt int
+	err := db.QueryRow("select count(*) from pragma_table_info(?1) where \"name\"==?2;",
+		table, column).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		_, err = db.Exec(fmt.Sprintf("alter table \"%s\" add column \"%s\" %s;", table, column, decl))
+	}
+	return err
+}
+
 func NewPool(client_db_loc string) (*Pool, error) {
 	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=rwc", client_db_loc))
 	if err != nil {
@@ -541,11 +1140,31 @@ func NewPool(client_db_loc string) (*Pool, error) {
 		"\"last_start\" text default (current_timestamp)," +
 		"\"stat_total\" int default 0," +
 		"\"stat_won\" int default 0," +
+		"\"stat_match_won\" int default 0," +
 		"\"settings\" text default ('{}')," +
+		"\"rating\" real default 1500," +
+		"\"rating_rd\" real default 350," +
+		"\"rating_vol\" real default 0.06," +
 		"unique (\"user_id\", \"chat_id\"));")
 	if err != nil {
 		return nil, err
 	}
+	err = addColumnIfNotExists(db, "users", "stat_match_won", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "users", "rating", "real default 1500")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "users", "rating_rd", "real default 350")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "users", "rating_vol", "real default 0.06")
+	if err != nil {
+		return nil, err
+	}
 	_, err = db.Exec("create table if not exists \"rooms\" (" +
 		"\"ext_user_id\" int not null," +
 		"\"ext_chat_id\" int not null," +
@@ -565,6 +1184,10 @@ func NewPool(client_db_loc string) (*Pool, error) {
 		"\"roomname\" text not null," +
 		"\"hash\" text not null," +
 		"\"gen_at\" text default (current_timestamp)," +
+		"\"kind\" int default 0," +
+		"\"uses\" int default 0," +
+		"\"revoked\" int default 0," +
+		"\"token_ver\" int default 0," +
 		"CONSTRAINT \"rooms_hashes_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
 		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
 		"unique (\"hash\"));")
@@ -578,6 +1201,8 @@ func NewPool(client_db_loc string) (*Pool, error) {
 		"\"muid\" int not null," +
 		"\"mcid\" int not null," +
 		"\"state\" text default '{}'," +
+		"\"role\" int default 0," +
+		"\"joined_at\" text default (current_timestamp)," +
 		"CONSTRAINT \"members_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
 		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
 		"CONSTRAINT \"members_fk_ext2\" FOREIGN KEY (\"muid\", \"mcid\") " +
@@ -586,41 +1211,221 @@ func NewPool(client_db_loc string) (*Pool, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	pool := &(Pool{
-		//terminate:  make(chan bool, 2),
-		client_db: db,
-		updates:   make(PoolUpdates, 128),
-	})
-
-	if pool.adduser_stmt, err = PrepareStmt(db,
-		"with _ex_ as (select * from \"users\" where \"user_id\"=?1 and \"chat_id\" = ?2 limit 1)"+
-			"replace into \"users\" "+
-			"(\"user_id\", \"chat_id\", \"user_name\", \"locale\", \"user_first_name\", \"user_second_name\", \"last_start\", \"stat_total\", \"stat_won\", \"settings\") "+
-			"values (?1, ?2, ?3, ?4, ?5, ?6, current_timestamp,"+
-			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"stat_total\" from _ex_) ELSE 0 end,"+
-			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"stat_won\" from _ex_) ELSE 0 end,"+
-			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"settings\" from _ex_) ELSE '{}' end);"); err != nil {
+	_, err = db.Exec("create table if not exists \"pending\" (" +
+		"\"euid\" int not null," +
+		"\"ecid\" int not null," +
+		"\"roomname\" text not null," +
+		"\"muid\" int not null," +
+		"\"mcid\" int not null," +
+		"\"req_at\" text default (current_timestamp)," +
+		"CONSTRAINT \"pending_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
+		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
+		"CONSTRAINT \"pending_fk_ext2\" FOREIGN KEY (\"muid\", \"mcid\") " +
+		"REFERENCES \"users\" (\"user_id\", \"chat_id\") on delete cascade," +
+		"unique (\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\"));")
+	if err != nil {
 		return nil, err
 	}
-	if pool.upduser_stmt, err = PrepareStmt(db,
-		"update \"users\" set \"settings\"=?3 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+	_, err = db.Exec("create table if not exists \"bans\" (" +
+		"\"euid\" int not null," +
+		"\"ecid\" int not null," +
+		"\"roomname\" text not null," +
+		"\"muid\" int not null," +
+		"\"user_name\" text not null," +
+		"\"banned_at\" text default (current_timestamp)," +
+		"CONSTRAINT \"bans_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
+		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
+		"unique (\"euid\", \"ecid\", \"roomname\", \"muid\"));")
+	if err != nil {
 		return nil, err
 	}
-	if pool.incuserstatt_stmt, err = PrepareStmt(db,
-		"update \"users\" set \"stat_total\"=\"stat_total\"+1 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+	_, err = db.Exec("create table if not exists \"pass_attempts\" (" +
+		"\"euid\" int not null," +
+		"\"ecid\" int not null," +
+		"\"roomname\" text not null," +
+		"\"muid\" int not null," +
+		"\"cnt\" int default 0," +
+		"\"since\" text default (current_timestamp)," +
+		"CONSTRAINT \"pass_attempts_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
+		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
+		"unique (\"euid\", \"ecid\", \"roomname\", \"muid\"));")
+	if err != nil {
 		return nil, err
 	}
-	if pool.incuserstatw_stmt, err = PrepareStmt(db,
-		"update \"users\" set \"stat_total\"=\"stat_total\"+1, \"stat_won\"=\"stat_won\"+1 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+	_, err = db.Exec("create table if not exists \"lobby\" (" +
+		"\"muid\" int not null," +
+		"\"mcid\" int not null," +
+		"\"players\" int not null," +
+		"\"winrate\" int default 0," +
+		"\"req_at\" text default (current_timestamp)," +
+		"CONSTRAINT \"lobby_fk_mem\" FOREIGN KEY (\"muid\", \"mcid\") " +
+		"REFERENCES \"users\" (\"user_id\", \"chat_id\") on delete cascade," +
+		"unique (\"muid\", \"mcid\"));")
+	if err != nil {
 		return nil, err
 	}
-	if pool.getuser_stmt, err = PrepareStmt(db,
-		"select * from \"users\" where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+	_, err = db.Exec("create table if not exists \"players\" (" +
+		"\"euid\" int not null," +
+		"\"ecid\" int not null," +
+		"\"roomname\" text not null," +
+		"\"muid\" int not null," +
+		"\"mcid\" int not null," +
+		"CONSTRAINT \"players_fk_ext\" FOREIGN KEY (\"euid\", \"ecid\", \"roomname\") " +
+		"REFERENCES \"rooms\" (\"ext_user_id\", \"ext_chat_id\", \"name\") on delete cascade," +
+		"CONSTRAINT \"players_fk_mem\" FOREIGN KEY (\"muid\", \"mcid\") " +
+		"REFERENCES \"users\" (\"user_id\", \"chat_id\") on delete cascade," +
+		"unique (\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\"));")
+	if err != nil {
 		return nil, err
 	}
-	if pool.getuserstat_stmt, err = PrepareStmt(db,
-		"select \"stat_total\", \"stat_won\" from \"users\" where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+	// the history is not bound to the rooms - the records outlive them
+	_, err = db.Exec("create table if not exists \"games\" (" +
+		"\"id\" integer primary key autoincrement," +
+		"\"euid\" int not null," +
+		"\"ecid\" int not null," +
+		"\"roomname\" text not null," +
+		"\"session\" int default 0," +
+		"\"mode\" int default 0," +
+		"\"started_at\" text default (current_timestamp)," +
+		"\"finished_at\" text);")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("create table if not exists \"rounds\" (" +
+		"\"game_id\" int not null," +
+		"\"round\" int not null," +
+		"\"winner\" int default 0," +
+		"\"winner_signs\" text default ''," +
+		"\"draw\" int default 0," +
+		"\"started_at\" text default (current_timestamp)," +
+		"\"finished_at\" text," +
+		"CONSTRAINT \"rounds_fk_game\" FOREIGN KEY (\"game_id\") " +
+		"REFERENCES \"games\" (\"id\") on delete cascade," +
+		"unique (\"game_id\", \"round\"));")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("create table if not exists \"moves\" (" +
+		"\"game_id\" int not null," +
+		"\"round\" int not null," +
+		"\"muid\" int not null," +
+		"\"mcid\" int not null," +
+		"\"user_name\" text default ''," +
+		"\"choose\" int default 0," +
+		"\"sign\" text default ''," +
+		"\"skip\" int default 0," +
+		"\"eliminated\" int default 0," +
+		"\"won\" int default 0," +
+		"\"made_at\" text default (current_timestamp)," +
+		"CONSTRAINT \"moves_fk_game\" FOREIGN KEY (\"game_id\") " +
+		"REFERENCES \"games\" (\"id\") on delete cascade," +
+		"unique (\"game_id\", \"round\", \"muid\", \"mcid\"));")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("create index if not exists \"moves_member\" on \"moves\" (\"muid\", \"mcid\");")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "rooms_hashes", "kind", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "rooms_hashes", "uses", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "rooms_hashes", "revoked", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "rooms_hashes", "token_ver", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = rotateRoomTokens(db)
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "members", "role", "int default 0")
+	if err != nil {
+		return nil, err
+	}
+	err = addColumnIfNotExists(db, "members", "joined_at", "text")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &(Pool{
+		//terminate:  make(chan bool, 2),
+		client_db: db,
+		updates:   make(PoolUpdates, 128),
+		timers:    make(map[string][]*time.Timer),
+		autostart: make(map[string]*time.Timer),
+
+		janitor_cfg: DefaultJanitorConfig(),
+	})
+
+	if pool.adduser_stmt, err = PrepareStmt(db,
+		"with _ex_ as (select * from \"users\" where \"user_id\"=?1 and \"chat_id\" = ?2 limit 1)"+
+			"replace into \"users\" "+
+			"(\"user_id\", \"chat_id\", \"user_name\", \"locale\", \"user_first_name\", \"user_second_name\", \"last_start\", \"stat_total\", \"stat_won\", \"stat_match_won\", \"settings\", \"rating\", \"rating_rd\", \"rating_vol\") "+
+			"values (?1, ?2, ?3, ?4, ?5, ?6, current_timestamp,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"stat_total\" from _ex_) ELSE 0 end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"stat_won\" from _ex_) ELSE 0 end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"stat_match_won\" from _ex_) ELSE 0 end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"settings\" from _ex_) ELSE '{}' end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"rating\" from _ex_) ELSE 1500 end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"rating_rd\" from _ex_) ELSE 350 end,"+
+			"CASE WHEN EXISTS(select * from _ex_) THEN (select \"rating_vol\" from _ex_) ELSE 0.06 end);"); err != nil {
+		return nil, err
+	}
+	if pool.upduser_stmt, err = PrepareStmt(db,
+		"update \"users\" set \"settings\"=?3 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.incuserstatt_stmt, err = PrepareStmt(db,
+		"update \"users\" set \"stat_total\"=\"stat_total\"+1 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.incuserstatw_stmt, err = PrepareStmt(db,
+		"update \"users\" set \"stat_total\"=\"stat_total\"+1, \"stat_won\"=\"stat_won\"+1 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.incuserstatm_stmt, err = PrepareStmt(db,
+		"update \"users\" set \"stat_match_won\"=\"stat_match_won\"+1 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.getuser_stmt, err = PrepareStmt(db,
+		"select * from \"users\" where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.addaiuser_stmt, err = PrepareStmt(db,
+		"insert into \"users\" (\"user_id\", \"chat_id\") "+
+			"select min(coalesce(min(\"user_id\"), 0), 0)-1, ?1 from \"users\" "+
+			"returning \"user_id\";"); err != nil {
+		return nil, err
+	}
+	// the synthetic users live while they are the members of some room.
+	// The just created ones are kept as they are joining the room yet
+	if pool.clraiusers_stmt, err = PrepareStmt(db,
+		"delete from \"users\" where \"user_id\" < 0 and "+
+			"coalesce(\"last_start\", '') < datetime('now', '-1 minutes') and "+
+			"not exists (select * from \"members\" where "+
+			"\"members\".\"muid\"==\"users\".\"user_id\" and \"members\".\"mcid\"==\"users\".\"chat_id\");"); err != nil {
+		return nil, err
+	}
+	if pool.getuserstat_stmt, err = PrepareStmt(db,
+		"select \"stat_total\", \"stat_won\", \"stat_match_won\" from \"users\" where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.getuserrating_stmt, err = PrepareStmt(db,
+		"select coalesce(\"rating\", 1500.0) as \"rating\", coalesce(\"rating_rd\", 350.0) as \"rating_rd\", "+
+			"coalesce(\"rating_vol\", 0.06) as \"rating_vol\" from \"users\" where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
+		return nil, err
+	}
+	if pool.upduserrating_stmt, err = PrepareStmt(db,
+		"update \"users\" set \"rating\"=?3, \"rating_rd\"=?4, \"rating_vol\"=?5 where \"user_id\"=?1 and \"chat_id\"=?2;"); err != nil {
 		return nil, err
 	}
 	if pool.addroom_stmt, err = PrepareStmt(db,
@@ -648,7 +1453,7 @@ func NewPool(client_db_loc string) (*Pool, error) {
 		return nil, err
 	}
 	if pool.updroomstate_stmt, err = PrepareStmt(db,
-		"update \"rooms\" set \"state\"=?4 where "+
+		"update \"rooms\" set \"state\"=?4, \"last_used\"=current_timestamp where "+
 			"\"ext_user_id\"=?1 and \"ext_chat_id\"=?2 and \"name\"=?3;"); err != nil {
 		return nil, err
 	}
@@ -659,17 +1464,34 @@ func NewPool(client_db_loc string) (*Pool, error) {
 	}
 	if pool.addroomhash_stmt, err = PrepareStmt(db,
 		"replace into \"rooms_hashes\" "+
-			"(\"euid\", \"ecid\", \"roomname\", \"hash\")"+
-			"values (?1, ?2, ?3, ?4);"); err != nil {
+			"(\"euid\", \"ecid\", \"roomname\", \"hash\", \"kind\", \"token_ver\")"+
+			"values (?1, ?2, ?3, ?4, ?5, ?6);"); err != nil {
 		return nil, err
 	}
+	// the expired and used up links are skipped like the revoked ones
 	if pool.getroomhash_stmt, err = PrepareStmt(db,
 		"select \"hash\" from \"rooms_hashes\" "+
-			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"kind\"==?4 and "+
+			"coalesce(\"revoked\", 0)==0 and "+
+			"(?5==0 or coalesce(cast((julianday('now') - julianday(\"gen_at\")) * 86400 as int), 0) < ?5) and "+
+			"(?6==0 or coalesce(\"uses\", 0) < ?6) "+
+			"order by \"gen_at\" desc;"); err != nil {
+		return nil, err
+	}
+	if pool.revokehash_stmt, err = PrepareStmt(db,
+		"update \"rooms_hashes\" set \"revoked\"=1 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"kind\"==?4;"); err != nil {
+		return nil, err
+	}
+	if pool.usehash_stmt, err = PrepareStmt(db,
+		"update \"rooms_hashes\" set \"uses\"=coalesce(\"uses\", 0)+1 where \"hash\"==?1;"); err != nil {
 		return nil, err
 	}
 	if pool.getroombyhash_stmt, err = PrepareStmt(db,
-		"select \"roomname\", \"user_name\", \"euid\", \"ecid\" from \"rooms_hashes\" inner join \"users\" on "+
+		"select \"roomname\", \"user_name\", \"euid\", \"ecid\", \"kind\", "+
+			"coalesce(\"uses\", 0) as \"uses\", coalesce(\"revoked\", 0) as \"revoked\", "+
+			"coalesce(cast((julianday('now') - julianday(\"gen_at\")) * 86400 as int), 0) as \"age\" "+
+			"from \"rooms_hashes\" inner join \"users\" on "+
 			"\"user_id\"==\"euid\" and \"chat_id\"==\"ecid\" where \"hash\" == ?1;"); err != nil {
 		return nil, err
 	}
@@ -684,8 +1506,8 @@ func NewPool(client_db_loc string) (*Pool, error) {
 	}
 	if pool.addmember_stmt, err = PrepareStmt(db,
 		"replace into \"members\" "+
-			"(\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\")"+
-			"values (?1, ?2, ?3, ?4, ?5);"); err != nil {
+			"(\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\", \"role\", \"state\", \"joined_at\")"+
+			"values (?1, ?2, ?3, ?4, ?5, ?6, ?7, current_timestamp);"); err != nil {
 		return nil, err
 	}
 	if pool.rmvmember_stmt, err = PrepareStmt(db,
@@ -724,10 +1546,343 @@ func NewPool(client_db_loc string) (*Pool, error) {
 	}
 	if pool.resetmemberst_stmt, err = PrepareStmt(db,
 		"update \"members\" "+
-			"set \"state\"='{}' "+
+			"set \"state\"=CASE WHEN \"role\"==1 THEN '{\"state\":1,\"spectator\":true}' ELSE '{}' end "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.addpending_stmt, err = PrepareStmt(db,
+		"replace into \"pending\" "+
+			"(\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\")"+
+			"values (?1, ?2, ?3, ?4, ?5);"); err != nil {
+		return nil, err
+	}
+	if pool.rmvpending_stmt, err = PrepareStmt(db,
+		"delete from \"pending\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4 and \"mcid\"==?5;"); err != nil {
+		return nil, err
+	}
+	if pool.clrpending_stmt, err = PrepareStmt(db,
+		"delete from \"pending\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.getpending_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"mcid\", \"user_name\", \"locale\" from \"pending\" "+
+			"inner join \"users\" on \"muid\"==\"user_id\" and \"mcid\" == \"chat_id\" "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 order by \"req_at\" asc;"); err != nil {
+		return nil, err
+	}
+	if pool.getpendingmem_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"mcid\", \"user_name\", \"locale\" from \"pending\" "+
+			"inner join \"users\" on \"muid\"==\"user_id\" and \"mcid\" == \"chat_id\" "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4 and \"mcid\"==?5;"); err != nil {
+		return nil, err
+	}
+	if pool.countroom_stmt, err = PrepareStmt(db,
+		"select count(*) as \"cnt\" from \"rooms\" "+
+			"where \"ext_user_id\"==?1 and \"ext_chat_id\"==?2 and \"name\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.getheir_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"mcid\", \"user_name\", \"locale\" from \"members\" "+
+			"inner join \"users\" on \"muid\"==\"user_id\" and \"mcid\" == \"chat_id\" "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\" > 0 and "+
+			"not (\"muid\"==?4 and \"mcid\"==?5) "+
+			"order by coalesce(\"joined_at\", '') asc, \"members\".\"rowid\" asc;"); err != nil {
+		return nil, err
+	}
+	if pool.xferroom_stmt, err = PrepareStmt(db,
+		"update \"rooms\" set \"ext_user_id\"=?4, \"ext_chat_id\"=?5, \"name\"=?6 "+
+			"where \"ext_user_id\"==?1 and \"ext_chat_id\"==?2 and \"name\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xferhashes_stmt, err = PrepareStmt(db,
+		"update \"rooms_hashes\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xfermembers_stmt, err = PrepareStmt(db,
+		"update \"members\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xferpending_stmt, err = PrepareStmt(db,
+		"update \"pending\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xferbans_stmt, err = PrepareStmt(db,
+		"update \"bans\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xferplayers_stmt, err = PrepareStmt(db,
+		"update \"players\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.xferpassattempt_stmt, err = PrepareStmt(db,
+		"update \"pass_attempts\" set \"euid\"=?4, \"ecid\"=?5, \"roomname\"=?6 "+
 			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
 		return nil, err
 	}
+	if pool.getownedrooms_stmt, err = PrepareStmt(db,
+		"select \"name\", \"state\", \"settings\", coalesce(\"last_used\", '') as \"last_used\", "+
+			"(select count(*) from \"members\" where \"euid\"==\"ext_user_id\" and "+
+			"\"ecid\"==\"ext_chat_id\" and \"roomname\"==\"name\") as \"cnt\" "+
+			"from \"rooms\" where \"ext_user_id\"==?1 and \"ext_chat_id\"==?2 "+
+			"order by \"last_used\" desc;"); err != nil {
+		return nil, err
+	}
+	if pool.delroom_stmt, err = PrepareStmt(db,
+		"delete from \"rooms\" where "+
+			"\"ext_user_id\"==?1 and \"ext_chat_id\"==?2 and \"name\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.delhashes_stmt, err = PrepareStmt(db,
+		"delete from \"rooms_hashes\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.delbans_stmt, err = PrepareStmt(db,
+		"delete from \"bans\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.delplayers_stmt, err = PrepareStmt(db,
+		"delete from \"players\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.addplayer_stmt, err = PrepareStmt(db,
+		"insert or ignore into \"players\" (\"euid\", \"ecid\", \"roomname\", \"muid\", \"mcid\") "+
+			"values (?1, ?2, ?3, ?4, ?5);"); err != nil {
+		return nil, err
+	}
+	// ?1 - the order, ?2 - the minimum games, ?3 - the limit, ?4 - the offset
+	top_query := func(cond string) string {
+		return "select \"user_id\" as \"muid\", \"chat_id\" as \"mcid\", \"user_name\", \"locale\", " +
+			"\"stat_total\", \"stat_won\", coalesce(\"rating\", 1500.0) as \"rating\", " +
+			"coalesce(\"rating_rd\", 350.0) as \"rating_rd\", coalesce(\"rating_vol\", 0.06) as \"rating_vol\" " +
+			"from \"users\" where \"user_id\" > 0 and \"stat_total\" >= ?2" + cond + " " +
+			"order by case ?1 " +
+			"when 0 then \"stat_won\" " +
+			"when 1 then \"stat_won\" * 1.0 / \"stat_total\" " +
+			"else coalesce(\"rating\", 1500.0) end desc, \"stat_total\" desc, \"user_name\" " +
+			"limit ?3 offset ?4;"
+	}
+	if pool.gettop_stmt, err = PrepareStmt(db, top_query("")); err != nil {
+		return nil, err
+	}
+	if pool.getchattop_stmt, err = PrepareStmt(db,
+		top_query(" and \"chat_id\"==?5")); err != nil {
+		return nil, err
+	}
+	// the overall results of the players who have ever played in the room
+	if pool.getroomtop_stmt, err = PrepareStmt(db,
+		top_query(" and exists (select * from \"players\" where "+
+			"\"euid\"==?5 and \"ecid\"==?6 and \"roomname\"==?7 and "+
+			"\"muid\"==\"users\".\"user_id\" and \"mcid\"==\"users\".\"chat_id\")")); err != nil {
+		return nil, err
+	}
+	if pool.addgame_stmt, err = PrepareStmt(db,
+		"insert into \"games\" (\"euid\", \"ecid\", \"roomname\", \"session\", \"mode\") "+
+			"values (?1, ?2, ?3, ?4, ?5);"); err != nil {
+		return nil, err
+	}
+	if pool.finishgame_stmt, err = PrepareStmt(db,
+		"update \"games\" set \"finished_at\"=current_timestamp where \"id\"==?1;"); err != nil {
+		return nil, err
+	}
+	if pool.addround_stmt, err = PrepareStmt(db,
+		"insert or ignore into \"rounds\" (\"game_id\", \"round\") values (?1, ?2);"); err != nil {
+		return nil, err
+	}
+	if pool.finishround_stmt, err = PrepareStmt(db,
+		"update \"rounds\" set \"winner\"=?3, \"winner_signs\"=?4, \"draw\"=?5, \"finished_at\"=current_timestamp "+
+			"where \"game_id\"==?1 and \"round\"==?2;"); err != nil {
+		return nil, err
+	}
+	if pool.addmove_stmt, err = PrepareStmt(db,
+		"insert into \"moves\" (\"game_id\", \"round\", \"muid\", \"mcid\", \"user_name\", \"choose\", \"sign\", \"skip\") "+
+			"values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8) "+
+			"on conflict (\"game_id\", \"round\", \"muid\", \"mcid\") do update set "+
+			"\"choose\"=?6, \"sign\"=?7, \"skip\"=?8, \"made_at\"=current_timestamp;"); err != nil {
+		return nil, err
+	}
+	// the member could have no move in the round when eliminated by the
+	// timeout or winning the scoring game without the last move
+	if pool.elimmove_stmt, err = PrepareStmt(db,
+		"insert into \"moves\" (\"game_id\", \"round\", \"muid\", \"mcid\", \"user_name\", \"skip\", \"eliminated\") "+
+			"values (?1, ?2, ?3, ?4, ?5, 1, 1) "+
+			"on conflict (\"game_id\", \"round\", \"muid\", \"mcid\") do update set \"eliminated\"=1;"); err != nil {
+		return nil, err
+	}
+	if pool.wonmove_stmt, err = PrepareStmt(db,
+		"insert into \"moves\" (\"game_id\", \"round\", \"muid\", \"mcid\", \"user_name\", \"skip\", \"won\") "+
+			"values (?1, ?2, ?3, ?4, ?5, 1, 1) "+
+			"on conflict (\"game_id\", \"round\", \"muid\", \"mcid\") do update set \"won\"=1;"); err != nil {
+		return nil, err
+	}
+	// ?1, ?2 - the member
+	history_query := func(cond string) string {
+		return "select \"games\".\"id\" as \"game_id\", \"games\".\"roomname\", \"games\".\"started_at\", " +
+			"coalesce(\"games\".\"finished_at\", '') as \"finished_at\", " +
+			"(select count(*) from \"rounds\" where \"rounds\".\"game_id\"==\"games\".\"id\" and " +
+			"\"rounds\".\"finished_at\" is not null) as \"rounds\", " +
+			"(select count(distinct \"m\".\"muid\") from \"moves\" as \"m\" where \"m\".\"game_id\"==\"games\".\"id\") as \"players\", " +
+			"max(\"moves\".\"won\") as \"won\", max(\"moves\".\"eliminated\") as \"eliminated\" " +
+			"from \"games\" inner join \"moves\" on \"moves\".\"game_id\"==\"games\".\"id\" " +
+			"where \"moves\".\"muid\"==?1 and \"moves\".\"mcid\"==?2" + cond + " " +
+			"group by \"games\".\"id\" order by \"games\".\"id\" desc"
+	}
+	if pool.gethistory_stmt, err = PrepareStmt(db,
+		history_query("")+" limit ?3 offset ?4;"); err != nil {
+		return nil, err
+	}
+	if pool.getgamerecord_stmt, err = PrepareStmt(db,
+		history_query(" and \"games\".\"id\"==?3")+";"); err != nil {
+		return nil, err
+	}
+	// the moves of the current round are hidden until it is finished
+	if pool.getroundrecords_stmt, err = PrepareStmt(db,
+		"select \"rounds\".\"round\", \"winner_signs\", \"draw\", "+
+			"coalesce(\"moves\".\"sign\", '') as \"sign\", coalesce(\"moves\".\"skip\", 1) as \"skip\" "+
+			"from \"rounds\" left join \"moves\" on \"moves\".\"game_id\"==\"rounds\".\"game_id\" and "+
+			"\"moves\".\"round\"==\"rounds\".\"round\" and \"moves\".\"muid\"==?2 and \"moves\".\"mcid\"==?3 "+
+			"where \"rounds\".\"game_id\"==?1 and \"rounds\".\"finished_at\" is not null "+
+			"order by \"rounds\".\"round\";"); err != nil {
+		return nil, err
+	}
+	if pool.getmoverecords_stmt, err = PrepareStmt(db,
+		"select \"user_name\", \"sign\", \"skip\", \"eliminated\", \"won\" from \"moves\" "+
+			"where \"game_id\"==?1 and \"round\"==?2 and exists (select * from \"rounds\" where "+
+			"\"rounds\".\"game_id\"==\"moves\".\"game_id\" and \"rounds\".\"round\"==\"moves\".\"round\" and "+
+			"\"rounds\".\"finished_at\" is not null) order by \"rowid\";"); err != nil {
+		return nil, err
+	}
+	if pool.getgesturefreqs_stmt, err = PrepareStmt(db,
+		"select \"sign\", count(*) as \"cnt\" from \"moves\" "+
+			"where \"muid\"==?1 and \"mcid\"==?2 and \"skip\"==0 "+
+			"group by \"sign\" order by \"cnt\" desc, \"sign\";"); err != nil {
+		return nil, err
+	}
+	if pool.getexportmoves_stmt, err = PrepareStmt(db,
+		"select \"moves\".\"rowid\" as \"id\", \"moves\".\"game_id\", \"games\".\"roomname\", \"games\".\"started_at\", "+
+			"coalesce(\"games\".\"finished_at\", '') as \"finished_at\", \"moves\".\"round\", \"moves\".\"sign\", "+
+			"\"moves\".\"skip\", \"moves\".\"eliminated\", \"moves\".\"won\", "+
+			"coalesce(\"rounds\".\"winner_signs\", '') as \"winner_signs\" from \"moves\" "+
+			"inner join \"games\" on \"games\".\"id\"==\"moves\".\"game_id\" "+
+			"left join \"rounds\" on \"rounds\".\"game_id\"==\"moves\".\"game_id\" and \"rounds\".\"round\"==\"moves\".\"round\" "+
+			"where \"moves\".\"muid\"==?1 and \"moves\".\"mcid\"==?2 and \"moves\".\"rowid\" > ?3 "+
+			"order by \"moves\".\"rowid\" limit ?4;"); err != nil {
+		return nil, err
+	}
+	if pool.getidlerooms_stmt, err = PrepareStmt(db,
+		"select \"ext_user_id\" as \"euid\", \"ext_chat_id\" as \"ecid\", \"name\", \"user_name\" "+
+			"from \"rooms\" inner join \"users\" on "+
+			"\"user_id\"==\"ext_user_id\" and \"chat_id\"==\"ext_chat_id\" "+
+			"where coalesce(\"last_used\", '') < datetime('now', ?1);"); err != nil {
+		return nil, err
+	}
+	if pool.getstartedrooms_stmt, err = PrepareStmt(db,
+		"select \"ext_user_id\" as \"euid\", \"ext_chat_id\" as \"ecid\", \"name\", \"user_name\" "+
+			"from \"rooms\" inner join \"users\" on "+
+			"\"user_id\"==\"ext_user_id\" and \"chat_id\"==\"ext_chat_id\" "+
+			"where json_extract(\"state\", '$.state')==?1;"); err != nil {
+		return nil, err
+	}
+	for _, table := range []string{"rooms_hashes", "members", "pending", "bans", "players", "pass_attempts"} {
+		stmt, err := PrepareStmt(db,
+			"delete from \""+table+"\" where not exists (select * from \"rooms\" where "+
+				"\"rooms\".\"ext_user_id\"==\""+table+"\".\"euid\" and "+
+				"\"rooms\".\"ext_chat_id\"==\""+table+"\".\"ecid\" and "+
+				"\"rooms\".\"name\"==\""+table+"\".\"roomname\");")
+		if err != nil {
+			return nil, err
+		}
+		pool.clrorphans_stmts = append(pool.clrorphans_stmts, stmt)
+	}
+	if pool.addlobby_stmt, err = PrepareStmt(db,
+		"replace into \"lobby\" (\"muid\", \"mcid\", \"players\", \"winrate\", \"req_at\") "+
+			"values (?1, ?2, ?3, ?4, current_timestamp);"); err != nil {
+		return nil, err
+	}
+	if pool.rmvlobby_stmt, err = PrepareStmt(db,
+		"delete from \"lobby\" where \"muid\"==?1 and \"mcid\"==?2;"); err != nil {
+		return nil, err
+	}
+	if pool.getlobbyentry_stmt, err = PrepareStmt(db,
+		"select \"players\" from \"lobby\" where \"muid\"==?1 and \"mcid\"==?2;"); err != nil {
+		return nil, err
+	}
+	if pool.getlobby_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"mcid\", \"user_name\", \"locale\", \"winrate\" from \"lobby\" "+
+			"inner join \"users\" on \"muid\"==\"user_id\" and \"mcid\" == \"chat_id\" "+
+			"where \"players\"==?1 and \"req_at\" >= datetime('now', ?2) order by \"req_at\" asc;"); err != nil {
+		return nil, err
+	}
+	if pool.getlobbyexp_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"mcid\", \"user_name\", \"locale\" from \"lobby\" "+
+			"inner join \"users\" on \"muid\"==\"user_id\" and \"mcid\" == \"chat_id\" "+
+			"where \"req_at\" < datetime('now', ?1);"); err != nil {
+		return nil, err
+	}
+	if pool.clrrevoked_stmt, err = PrepareStmt(db,
+		"delete from \"rooms_hashes\" where coalesce(\"revoked\", 0)!=0 and "+
+			"coalesce(\"gen_at\", '') < datetime('now', ?1);"); err != nil {
+		return nil, err
+	}
+	if pool.addban_stmt, err = PrepareStmt(db,
+		"replace into \"bans\" "+
+			"(\"euid\", \"ecid\", \"roomname\", \"muid\", \"user_name\")"+
+			"values (?1, ?2, ?3, ?4, ?5);"); err != nil {
+		return nil, err
+	}
+	if pool.rmvban_stmt, err = PrepareStmt(db,
+		"delete from \"bans\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4;"); err != nil {
+		return nil, err
+	}
+	if pool.getbans_stmt, err = PrepareStmt(db,
+		"select \"muid\", \"user_name\" from \"bans\" "+
+			"where \"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 order by \"banned_at\" asc;"); err != nil {
+		return nil, err
+	}
+	if pool.isbanned_stmt, err = PrepareStmt(db,
+		"select count(*) as \"cnt\" from \"bans\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4;"); err != nil {
+		return nil, err
+	}
+	if pool.getpassattempt_stmt, err = PrepareStmt(db,
+		"select \"cnt\" from \"pass_attempts\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4 and "+
+			"\"since\" >= datetime('now', ?5);"); err != nil {
+		return nil, err
+	}
+	// the counter starts again when the period has passed
+	if pool.addpassattempt_stmt, err = PrepareStmt(db,
+		"insert into \"pass_attempts\" (\"euid\", \"ecid\", \"roomname\", \"muid\", \"cnt\", \"since\") "+
+			"values (?1, ?2, ?3, ?4, 1, current_timestamp) "+
+			"on conflict (\"euid\", \"ecid\", \"roomname\", \"muid\") do update set "+
+			"\"cnt\"=case when \"since\" < datetime('now', ?5) then 1 else \"cnt\"+1 end, "+
+			"\"since\"=case when \"since\" < datetime('now', ?5) then current_timestamp else \"since\" end;"); err != nil {
+		return nil, err
+	}
+	if pool.rmvpassattempt_stmt, err = PrepareStmt(db,
+		"delete from \"pass_attempts\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3 and \"muid\"==?4;"); err != nil {
+		return nil, err
+	}
+	if pool.clrpassattempts_stmt, err = PrepareStmt(db,
+		"delete from \"pass_attempts\" where "+
+			"\"euid\"==?1 and \"ecid\"==?2 and \"roomname\"==?3;"); err != nil {
+		return nil, err
+	}
+	if pool.exppassattempts_stmt, err = PrepareStmt(db,
+		"delete from \"pass_attempts\" where \"since\" < datetime('now', ?1);"); err != nil {
+		return nil, err
+	}
 
 	return pool, nil
 }
@@ -745,13 +1900,13 @@ func (pool *Pool) NewPoolRoom(client *PoolClient, name string) (*PoolRoom, error
 }
 
 func (pool *Pool) GetPoolUpdates() PoolUpdates {
-	/*go func() {
-		for {
-			// some idle work
-
-			time.Sleep(time.Second * 5)
+	pool.janitor_once.Do(func() {
+		// the timers of the started games are lost with the previous run
+		if err := pool.resumeGames(); err != nil {
+			log.Printf("Resume games: %v", err)
 		}
-	}()*/
+		go pool.janitor()
+	})
 
 	return pool.updates
 }
@@ -895,15 +2050,17 @@ func (pool *Pool) GetUser(id TgUserId) (*PoolClient, error) {
 		GetLocale(cols[LOCALE_COL.name].(string)))
 }
 
-func (pool *Pool) GetUserStat(id *TgUserId) (int, int, error) {
+func (pool *Pool) GetUserStat(id *TgUserId) (int, int, int, error) {
 	cols, err := pool.getuserstat_stmt.DoSelectRow(
 		[]any{id.user_id, id.chat_id},
-		[]variantParam{STAT_TOTAL_COL, STAT_WON_COL})
+		[]variantParam{STAT_TOTAL_COL, STAT_WON_COL, STAT_MATCH_WON_COL})
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 
-	return int(cols[STAT_TOTAL_COL.name].(int64)), int(cols[STAT_WON_COL.name].(int64)), nil
+	return int(cols[STAT_TOTAL_COL.name].(int64)),
+		int(cols[STAT_WON_COL.name].(int64)),
+		int(cols[STAT_MATCH_WON_COL.name].(int64)), nil
 }
 
 func (pool *Pool) GetMembers(room *PoolRoom) ([]*PoolClient, error) {
@@ -973,6 +2130,28 @@ func (pool *Pool) forEachMemberDo(room *PoolRoom, do_what DoWithMember, params [
 }
 
 func (pool *Pool) AddMember(joinroom *PoolRoom, client *PoolClient) error {
+	return pool.addMemberWithRole(joinroom, client, MEMBER_PLAYER)
+}
+
+func (pool *Pool) AddSpectator(joinroom *PoolRoom, client *PoolClient) error {
+	return pool.addMemberWithRole(joinroom, client, MEMBER_SPECTATOR)
+}
+
+func (pool *Pool) addMemberWithRole(joinroom *PoolRoom, client *PoolClient, role int) error {
+	return pool.addMemberWithState(joinroom, client, role, memberInitState(role))
+}
+
+func (pool *Pool) addMemberWithState(joinroom *PoolRoom, client *PoolClient, role int, state string) error {
+	if role == MEMBER_PLAYER {
+		full, err := pool.isRoomFull(joinroom, client)
+		if err != nil {
+			return err
+		}
+		if full {
+			return ThrowRoomFull(client.GetLocale())
+		}
+	}
+
 	// check if we remove member
 	curroom, err := pool.GetRoomForClient(client)
 	if curroom != nil && err != nil &&
@@ -990,7 +2169,9 @@ func (pool *Pool) AddMember(joinroom *PoolRoom, client *PoolClient) error {
 			joinroom.ownerid.chat_id,
 			joinroom.name,
 			client.id.user_id,
-			client.id.chat_id})
+			client.id.chat_id,
+			role,
+			state})
 
 	if err != nil {
 		return err
@@ -1004,28 +2185,224 @@ func (pool *Pool) AddMember(joinroom *PoolRoom, client *PoolClient) error {
 		pool.updates <- upd
 		return nil
 	}, []any{client.user_name})
-
-	return err
-}
-
-func (pool *Pool) GetRoomWithHash(client *PoolClient, hash string) (*PoolRoom, error) {
-
-	if len(hash) == 0 {
-		return nil, ThrowNotValidRoom(client.GetLocale())
+	if err != nil {
+		return err
 	}
 
-	cols, err := pool.getroombyhash_stmt.DoSelectRow(
-		[]any{
-			hash},
-		[]variantParam{ROOMNAME_COL, USERNAME_COL, EUID_COL, ECID_COL})
-
-	if err == sql.ErrNoRows {
-		return nil, ThrowNotValidRoom(client.GetLocale())
+	if role == MEMBER_PLAYER {
+		return pool.checkAutoStart(joinroom)
 	}
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// isRoomFull checks if the new player can not join the room
+func (pool *Pool) isRoomFull(room *PoolRoom, client *PoolClient) (bool, error) {
+	max_players := room.GetRoomSettings().GetMaxPlayers()
+	if max_players == 0 {
+		return false, nil
 	}
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return false, err
+	}
+	for _, mem := range members {
+		if mem.id.Compare(client.GetID()) == 0 && !mem.player.Spectator {
+			// already plays here
+			return false, nil
+		}
+	}
+	return countPlayers(members) >= max_players, nil
+}
+
+// isAutoStartReady checks if the room has reached the configured size
+// (the maximum or the minimum if there is no maximum) between the games.
+// The queued players are counted too as they join with the restart
+func (pool *Pool) isAutoStartReady(room *PoolRoom) (bool, []*PoolClient, error) {
+	setts := room.GetRoomSettings()
+	if setts == nil || !setts.AutoStart {
+		return false, nil, nil
+	}
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return false, nil, err
+	}
+	if state.State != GST_WAITING && state.State != GST_ROOM_CLOSED_WAIT_TO_START {
+		return false, nil, nil
+	}
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return false, nil, err
+	}
+	players := countPlayers(members)
+	if state.State == GST_ROOM_CLOSED_WAIT_TO_START {
+		pending, err := pool.GetPendingMembers(room)
+		if err != nil {
+			return false, nil, err
+		}
+		humans := len(pending)
+		for _, mem := range members {
+			if !mem.player.Spectator && !mem.GetID().IsAI() {
+				humans++
+			}
+		}
+		if humans == 0 {
+			// the AI members do not play again by themselves
+			return false, nil, nil
+		}
+		players += len(pending)
+	}
+	size := setts.GetMaxPlayers()
+	if size == 0 {
+		size = setts.GetMinPlayers()
+	}
+	return players >= size, members, nil
+}
+
+// checkAutoStart starts the countdown if the room is ready
+func (pool *Pool) checkAutoStart(room *PoolRoom) error {
+	ready, members, err := pool.isAutoStartReady(room)
+	if err != nil || !ready {
+		return err
+	}
+
+	pool.timers_mux.Lock()
+	if _, ok := pool.autostart[room.key()]; ok {
+		pool.timers_mux.Unlock()
+		return nil
+	}
+	pool.autostart[room.key()] = time.AfterFunc(AUTOSTART_DELAY, func() {
+		pool.timers_mux.Lock()
+		delete(pool.autostart, room.key())
+		pool.timers_mux.Unlock()
+
+		err := pool.autoStart(room)
+		if err != nil {
+			pool.notifyAutoStartFailed(room, err)
+		}
+	})
+	pool.timers_mux.Unlock()
+
+	for _, mem := range members {
+		upd := PoolUpdate{
+			Type:   UPD_AUTOSTART,
+			Params: []any{mem, room, int64(AUTOSTART_DELAY / time.Second)}}
+		pool.updates <- upd
+	}
+	return nil
+}
+
+// autoStart starts the first game or restarts the finished one
+func (pool *Pool) autoStart(room *PoolRoom) error {
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return err
+	}
+	if state.State == GST_STARTED {
+		// the owner has started the game already
+		return nil
+	}
+	return pool.RestartRoom(room)
+}
+
+func (pool *Pool) notifyAutoStartFailed(room *PoolRoom, reason error) {
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return
+	}
+	for _, mem := range members {
+		upd := PoolUpdate{
+			Type:   UPD_AUTOSTART_FAILED,
+			Params: []any{mem, room, reason}}
+		pool.updates <- upd
+	}
+}
+
+// cancelAutoStart stops the countdown if the room is not ready anymore
+func (pool *Pool) cancelAutoStart(room *PoolRoom) error {
+	pool.timers_mux.Lock()
+	_, ok := pool.autostart[room.key()]
+	pool.timers_mux.Unlock()
+	if !ok {
+		return nil
+	}
+
+	ready, _, err := pool.isAutoStartReady(room)
+	if err != nil || ready {
+		return err
+	}
+	pool.stopAutoStart(room)
+	pool.notifyAutoStartFailed(room, ErrNotEnoughPlayers)
+	return nil
+}
+
+// moveAutoStart restarts the countdown of the re-keyed room
+func (pool *Pool) moveAutoStart(room *PoolRoom, new_room *PoolRoom) error {
+	pool.timers_mux.Lock()
+	timer, ok := pool.autostart[room.key()]
+	if ok {
+		timer.Stop()
+		delete(pool.autostart, room.key())
+	}
+	pool.timers_mux.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return pool.checkAutoStart(new_room)
+}
+
+func (pool *Pool) stopAutoStart(room *PoolRoom) {
+	pool.timers_mux.Lock()
+	defer pool.timers_mux.Unlock()
+
+	if timer, ok := pool.autostart[room.key()]; ok {
+		timer.Stop()
+		delete(pool.autostart, room.key())
+	}
+}
+
+func (pool *Pool) GetRoomWithHash(client *PoolClient, hash string) (*PoolRoom, error) {
+	room, _, err := pool.getRoomWithHash(client, hash)
+	return room, err
+}
+
+// roomHashInfo describes the invite link used to find the room
+type roomHashInfo struct {
+	kind    int
+	uses    int
+	revoked bool
+	age     time.Duration
+}
+
+func (pool *Pool) getRoomWithHash(client *PoolClient, hash string) (*PoolRoom, int, error) {
+	room, info, err := pool.getRoomWithHashInfo(client, hash)
+	return room, info.kind, err
+}
+
+func (pool *Pool) getRoomWithHashInfo(client *PoolClient, hash string) (*PoolRoom, roomHashInfo, error) {
+	info := roomHashInfo{kind: HASH_INVITE}
+
+	if len(hash) == 0 {
+		return nil, info, ThrowNotValidRoom(client.GetLocale())
+	}
+
+	cols, err := pool.getroombyhash_stmt.DoSelectRow(
+		[]any{
+			hash},
+		[]variantParam{ROOMNAME_COL, USERNAME_COL, EUID_COL, ECID_COL, KIND_COL,
+			USES_COL, REVOKED_COL, AGE_COL})
+
+	if err == sql.ErrNoRows {
+		return nil, info, ThrowNotValidRoom(client.GetLocale())
+	}
+
+	if err != nil {
+		return nil, info, err
+	}
+	info.kind = int(cols[KIND_COL.name].(int64))
+	info.uses = int(cols[USES_COL.name].(int64))
+	info.revoked = cols[REVOKED_COL.name].(int64) != 0
+	info.age = time.Duration(cols[AGE_COL.name].(int64)) * time.Second
 
 	euid := cols[EUID_COL.name].(int64)
 	ecid := cols[ECID_COL.name].(int64)
@@ -1035,27 +2412,99 @@ func (pool *Pool) GetRoomWithHash(client *PoolClient, hash string) (*PoolRoom, e
 		&PoolClient{id: tgid, user_name: cols[USERNAME_COL.name].(string)},
 		cols[ROOMNAME_COL.name].(string), true, client.locale)
 	if err != nil {
-		return nil, err
+		return nil, info, err
 	}
-	return room, nil
+	return room, info, nil
+}
+
+// checkInvite checks if the invite link is still valid to join the room
+func (pool *Pool) checkInvite(room *PoolRoom, client *PoolClient, info roomHashInfo) error {
+	if room.ownerid.Compare(client.GetID()) == 0 {
+		// the owner can always get back
+		return nil
+	}
+	if info.revoked {
+		return ThrowInviteRevoked(client.GetLocale())
+	}
+	setts := room.GetRoomSettings()
+	if ttl := setts.GetInviteTTL(); ttl > 0 && info.age >= time.Duration(ttl)*time.Hour {
+		return ThrowInviteExpired(client.GetLocale())
+	}
+	if uses := setts.GetInviteUses(); uses > 0 && info.uses >= uses {
+		return ThrowInviteExpired(client.GetLocale())
+	}
+	return nil
 }
 
 func (pool *Pool) AuthorizeWithHash(client *PoolClient, hash string) (*PoolRoom, error) {
+	return pool.authorizeWithHash(client, hash, nil)
+}
+
+// AuthorizeWithPassword joins the password protected room
+func (pool *Pool) AuthorizeWithPassword(client *PoolClient, hash string, pass string) (*PoolRoom, error) {
+	return pool.authorizeWithHash(client, hash, &pass)
+}
+
+func (pool *Pool) authorizeWithHash(client *PoolClient, hash string, pass *string) (*PoolRoom, error) {
 	client.SetStatus(StatusWaiting)
 
-	room, err := pool.GetRoomWithHash(client, hash)
+	room, info, err := pool.getRoomWithHashInfo(client, hash)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ThrowNotValidRoom(client.GetLocale())
 		}
 		return nil, err
 	}
+	kind := info.kind
 
-	if room.GetGame().State != int(GST_WAITING) {
-		return room, ThrowRoomClosed(client.GetLocale())
+	banned, err := pool.IsBanned(room, client)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, ThrowBanned(client.GetLocale())
 	}
 
-	err = pool.AddMember(room, client)
+	err = pool.checkInvite(room, client, info)
+	if err != nil {
+		return nil, err
+	}
+
+	if room.GetRoomSettings().HasPassword() && room.ownerid.Compare(client.GetID()) != 0 {
+		if pass == nil {
+			return room, ErrPasswordRequired
+		}
+		err = pool.checkPassword(room, client, *pass)
+		if err != nil {
+			return room, err
+		}
+	}
+
+	if kind == HASH_SPECTATE {
+		// spectators can join the room at any moment
+		err = pool.AddSpectator(room, client)
+		if err == nil && room.GetGame().State == GST_STARTED {
+			upd := PoolUpdate{
+				Type:   UPD_WAIT_FOR_TURN,
+				Params: []any{client, int64(room.GetGame().Round)}}
+			pool.updates <- upd
+		}
+	} else {
+		if room.GetGame().State != int(GST_WAITING) {
+			// the game is in progress - wait for the next one
+			err = pool.QueueMember(room, client)
+			if err != nil {
+				return room, err
+			}
+			return room, pool.useHash(room, client, hash)
+		}
+
+		err = pool.AddMember(room, client)
+	}
+	if err != nil {
+		return room, err
+	}
+	err = pool.useHash(room, client, hash)
 	if err != nil {
 		return room, err
 	}
@@ -1064,6 +2513,93 @@ func (pool *Pool) AuthorizeWithHash(client *PoolClient, hash string) (*PoolRoom,
 	return room, nil
 }
 
+func passAttemptsPeriod() string {
+	return fmt.Sprintf("-%d seconds", int(PASS_ATTEMPTS_PERIOD/time.Second))
+}
+
+// takePassAttempt counts the attempt to enter the password and returns
+// the number of the attempts made in the period including this one
+func (pool *Pool) takePassAttempt(bindings []any) (int, error) {
+	pool.pass_mux.Lock()
+	defer pool.pass_mux.Unlock()
+
+	cnt := 0
+	cols, err := pool.getpassattempt_stmt.DoSelectRow(bindings, []variantParam{CNT_COL})
+	if err == nil {
+		cnt = int(cols[CNT_COL.name].(int64))
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+	if cnt >= MAX_PASS_ATTEMPTS {
+		return cnt + 1, nil
+	}
+	return cnt + 1, pool.addpassattempt_stmt.DoUpdate(bindings)
+}
+
+// checkPassword checks the password against the room settings. The
+// attempts are counted in the database so the limit survives the
+// restarts. The attempt is taken before the slow hashing so the parallel
+// attempts can not exceed the limit
+func (pool *Pool) checkPassword(room *PoolRoom, client *PoolClient, pass string) error {
+	bindings := []any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name,
+		client.id.user_id,
+		passAttemptsPeriod()}
+	cnt, err := pool.takePassAttempt(bindings)
+	if err != nil {
+		return err
+	}
+	if cnt > MAX_PASS_ATTEMPTS {
+		return ThrowTooManyAttempts(client.GetLocale())
+	}
+
+	setts := room.GetRoomSettings()
+	ok, err := CheckPasswordHash(setts.PassSalt, setts.PassHash, pass)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ThrowWrongPassword(client.GetLocale(), MAX_PASS_ATTEMPTS-cnt)
+	}
+	return pool.rmvpassattempt_stmt.DoUpdate(bindings[:4])
+}
+
+// SetRoomPassword sets the salted hash of the room password (or
+// removes the password if it is empty). The password can be changed
+// at any moment
+func (pool *Pool) SetRoomPassword(room *PoolRoom, pass string) error {
+	setts := *room.GetRoomSettings()
+	if len(pass) == 0 {
+		setts.PassSalt = ""
+		setts.PassHash = ""
+	} else {
+		salt, err := GenSalt()
+		if err != nil {
+			return err
+		}
+		hash, err := PasswordHash(salt, pass)
+		if err != nil {
+			return err
+		}
+		setts.PassSalt = salt
+		setts.PassHash = hash
+	}
+
+	err := pool.updateClientRoomSettings(&PoolClient{id: room.ownerid, locale: DefaultLocale()}, room.name, &setts)
+	if err != nil {
+		return err
+	}
+	room.setts = &setts
+
+	// the new password gives the new attempts
+	return pool.clrpassattempts_stmt.DoUpdate([]any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name})
+}
+
 func (pool *Pool) AuthorizeToRoom(owner *PoolClient, room_name string, client *PoolClient) (*PoolRoom, error) {
 	client.SetStatus(StatusWaiting)
 
@@ -1103,17 +2639,29 @@ func (pool *Pool) AuthorizeWithRoomName(client *PoolClient, name string) (*PoolR
 }
 
 func (pool *Pool) GetHashForRoom(room *PoolRoom) (string, error) {
+	return pool.getHashForRoom(room, HASH_INVITE)
+}
+
+func (pool *Pool) GetSpectatorHashForRoom(room *PoolRoom) (string, error) {
+	return pool.getHashForRoom(room, HASH_SPECTATE)
+}
+
+func (pool *Pool) getHashForRoom(room *PoolRoom, kind int) (string, error) {
+	setts := room.GetRoomSettings()
 	cols, err := pool.getroomhash_stmt.DoSelectRow(
 		[]any{
 			room.ownerid.user_id,
 			room.ownerid.chat_id,
-			room.GetName()},
+			room.GetName(),
+			kind,
+			setts.GetInviteTTL() * int(time.Hour/time.Second),
+			setts.GetInviteUses()},
 		[]variantParam{HASH_COL})
 	if err != nil && err != sql.ErrNoRows {
 		return "", err
 	}
 	if err == sql.ErrNoRows {
-		hashv, err := pool.GenRoomHash(room)
+		hashv, err := pool.GenRoomHash(room, kind)
 		if err != nil {
 			return "", err
 		}
@@ -1122,19 +2670,39 @@ func (pool *Pool) GetHashForRoom(room *PoolRoom) (string, error) {
 	return cols[HASH_COL.name].(string), nil
 }
 
-func (pool *Pool) GenRoomHash(room *PoolRoom) (string, error) {
-	var xor_id int64 = (room.ownerid.user_id ^ room.ownerid.chat_id) | room.ownerid.user_id
+// useHash counts the join made with the invite link
+func (pool *Pool) useHash(room *PoolRoom, client *PoolClient, hash string) error {
+	if room.ownerid.Compare(client.GetID()) == 0 {
+		return nil
+	}
+	return pool.usehash_stmt.DoUpdate([]any{hash})
+}
+
+// RevokeRoomHash makes the current invite links of the room invalid.
+// The new links will be generated on the next request
+func (pool *Pool) RevokeRoomHash(room *PoolRoom) error {
+	for _, kind := range []int{HASH_INVITE, HASH_SPECTATE} {
+		err := pool.revokehash_stmt.DoUpdate(
+			[]any{
+				room.ownerid.user_id,
+				room.ownerid.chat_id,
+				room.GetName(),
+				kind})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (pool *Pool) GenRoomHash(room *PoolRoom, kind int) (string, error) {
 	var hash_value string
 	for {
-		bytew := bytes.NewBuffer(make([]byte, 0, 9))
-		binary.Write(bytew, binary.LittleEndian, int32(xor_id&0xffffffff))
-		binary.Write(bytew, binary.LittleEndian, int16((xor_id>>32)&0xffff))
-		binary.Write(bytew, binary.LittleEndian, int16(time.Now().UnixMilli()%100000))
-		binary.Write(bytew, binary.LittleEndian, byte(rand.IntN(256)))
-		hash_value = base64.URLEncoding.EncodeToString(bytew.Bytes())
-		hash_value = strings.ReplaceAll(hash_value, "-", "AA")
-		hash_value = strings.ReplaceAll(hash_value, "_", "bb")
+		var err error
+		hash_value, err = GenRoomToken()
+		if err != nil {
+			return "", err
+		}
 		cols, err := pool.findroombyhash_stmt.DoSelectRow(
 			[]any{
 				hash_value},
@@ -1151,6 +2719,8 @@ func (pool *Pool) GenRoomHash(room *PoolRoom) (string, error) {
 			room.ownerid.chat_id,
 			room.name,
 			hash_value,
+			kind,
+			ROOM_TOKEN_VER,
 		})
 	return hash_value, err
 }
@@ -1163,26 +2733,21 @@ func (pool *Pool) ExitRoom(room *PoolRoom, client *PoolClient) error {
 			return err
 		}
 		if room.ownerid.Compare(client.GetID()) == 0 {
-			err := pool.clrmembers_stmt.DoUpdate(
-				[]any{
-					room.ownerid.user_id,
-					room.ownerid.chat_id,
-					room.name,
-				})
-			if err != nil {
-				return err
-			}
-			err = pool.UpdateRoomState(room, &PoolGame{})
-			if err != nil {
-				return err
-			}
-			// send "room finished" event
-			for _, id := range members {
-				upd := PoolUpdate{
-					Type:   UPD_ROOM_FINISHED,
-					Params: []any{id, room}}
-				pool.updates <- upd
+			if room.GetRoomSettings().GetOwnerLeave() == OWNER_LEAVE_TRANSFER {
+				heir, err := pool.getHeir(room)
+				if err != nil {
+					return err
+				}
+				if heir != nil {
+					new_room, err := pool.TransferOwnership(room, heir)
+					if err != nil {
+						return err
+					}
+					// leave the room as the ordinary member
+					return pool.ExitRoom(new_room, client)
+				}
 			}
+			return pool.ResetRoom(room)
 		} else {
 			err := pool.rmvmember_stmt.DoUpdate(
 				[]any{
@@ -1195,6 +2760,12 @@ func (pool *Pool) ExitRoom(room *PoolRoom, client *PoolClient) error {
 			if err != nil {
 				return err
 			}
+			if client.GetID().IsAI() {
+				err = pool.clraiusers_stmt.DoUpdate([]any{})
+				if err != nil {
+					return err
+				}
+			}
 			// send disconnection event
 			for _, id := range members {
 				upd := PoolUpdate{
@@ -1202,257 +2773,1331 @@ func (pool *Pool) ExitRoom(room *PoolRoom, client *PoolClient) error {
 					Params: []any{id, NewRoom(room.ownername, room.ownerid, room.name), client.user_name}}
 				pool.updates <- upd
 			}
+			// the round could wait only for the member who left
+			go pool.resumeRound(room)
+			return pool.cancelAutoStart(room)
 		}
 	}
 	return nil
 }
 
-func (pool *Pool) RestartRoom(room *PoolRoom) error {
-	if room != nil {
-		state := &PoolGame{State: GST_ROOM_CLOSED_WAIT_TO_START}
+// ResetRoom removes all members from the room and resets the game
+func (pool *Pool) ResetRoom(room *PoolRoom) error {
+	return pool.resetRoom(room, UPD_ROOM_FINISHED)
+}
 
-		err := pool.UpdateRoomState(room, state)
-		if err != nil {
-			return err
-		}
-		err = pool.ResetMembersState(room)
-		if err != nil {
-			return err
-		}
-		// send "room closed" event
-		err = pool.forEachMemberDo(room, func(room_ *PoolRoom, mem_ *PoolClient, params_ []any) error {
-			upd := PoolUpdate{
-				Type:   UPD_ROOM_CLOSED,
-				Params: []any{mem_, room_}}
-			pool.updates <- upd
-			return nil
-		}, []any{})
-		if err == sql.ErrNoRows {
-			return ErrNoActiveRooms
+// resetRoom removes all members from the room and notifies them with
+// the update of the given type
+func (pool *Pool) resetRoom(room *PoolRoom, upd_type PoolUpdateType) error {
+	members, err := pool.GetMemberIds(room)
+	if err != nil {
+		return err
+	}
+
+	pool.stopTurnTimers(room)
+	pool.stopAutoStart(room)
+
+	err = pool.clrmembers_stmt.DoUpdate(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+		})
+	if err != nil {
+		return err
+	}
+	err = pool.clrpending_stmt.DoUpdate(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+		})
+	if err != nil {
+		return err
+	}
+	err = pool.clraiusers_stmt.DoUpdate([]any{})
+	if err != nil {
+		return err
+	}
+	err = pool.UpdateRoomState(room, &PoolGame{})
+	if err != nil {
+		return err
+	}
+	// send "room finished" event
+	for _, id := range members {
+		upd := PoolUpdate{
+			Type:   upd_type,
+			Params: []any{id, room}}
+		pool.updates <- upd
+	}
+	return nil
+}
+
+// getHeir returns the longest present human member of the room except
+// the owner or nil if there is nobody to hand the room over
+func (pool *Pool) getHeir(room *PoolRoom) (*PoolClient, error) {
+	cols, err := pool.getheir_stmt.DoSelectRow(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			room.ownerid.user_id,
+			room.ownerid.chat_id},
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PoolClient{
+		id: TgUserId{
+			cols[MUID_COL.name].(int64),
+			cols[MCID_COL.name].(int64)},
+		user_name: cols[USERNAME_COL.name].(string),
+		locale:    GetLocale(cols[LOCALE_COL.name].(string)),
+	}, nil
+}
+
+// TransferOwnership hands the room over to the member and re-keys the
+// room, its hashes, members and the join queue. The game continues
+// with the returned room
+func (pool *Pool) TransferOwnership(room *PoolRoom, heir *PoolClient) (*PoolRoom, error) {
+	// the round should not be finished under the old key meanwhile
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
+
+	if heir.GetID().IsAI() || room.ownerid.Compare(heir.GetID()) == 0 {
+		return nil, ErrNotMember
+	}
+	mem_room, err := pool.GetRoomForClient(heir)
+	if err != nil {
+		return nil, err
+	}
+	if mem_room == nil || mem_room.key() != room.key() {
+		return nil, ErrNotMember
+	}
+
+	cols, err := pool.countroom_stmt.DoSelectRow(
+		[]any{heir.id.user_id, heir.id.chat_id, room.name},
+		[]variantParam{CNT_COL})
+	if err != nil {
+		return nil, err
+	}
+	if cols[CNT_COL.name].(int64) > 0 {
+		return nil, ErrOwnerHasRoom
+	}
+
+	tx, err := pool.client_db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	bindings := []any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name,
+		heir.id.user_id,
+		heir.id.chat_id,
+		room.name}
+	err = pool.rekeyRoom(tx, bindings)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	new_room, err := pool.GenRoom(heir, room.name, false, heir.locale)
+	if err != nil {
+		return nil, err
+	}
+
+	// the timers and the AI moves are bound to the old room key
+	pool.stopTurnTimers(room)
+	err = pool.resumeTurn(new_room)
+	if err != nil {
+		return nil, err
+	}
+	err = pool.moveAutoStart(room, new_room)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pool.forEachMemberDo(new_room, func(room_ *PoolRoom, mem_ *PoolClient, params_ []any) error {
+		upd := PoolUpdate{
+			Type:   UPD_OWNER_CHANGED,
+			Params: []any{mem_, room_}}
+		pool.updates <- upd
+		return nil
+	}, []any{})
+	if err != nil {
+		return nil, err
+	}
+	return new_room, nil
+}
+
+// rekeyRoom moves the room and all its records to the new owner and name
+func (pool *Pool) rekeyRoom(tx *sql.Tx, bindings []any) error {
+	for _, stmt := range []*StmtWrapper{
+		pool.xferroom_stmt,
+		pool.xferhashes_stmt,
+		pool.xfermembers_stmt,
+		pool.xferpending_stmt,
+		pool.xferbans_stmt,
+		pool.xferplayers_stmt,
+		pool.xferpassattempt_stmt} {
+		err := stmt.DoUpdateTx(tx, bindings)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PoolRoomInfo is the short description of the room for its owner
+type PoolRoomInfo struct {
+	Room     *PoolRoom
+	Members  int
+	LastUsed string
+}
+
+// GetOwnedRooms returns the rooms of the client ordered from the most
+// recently used one
+func (pool *Pool) GetOwnedRooms(client *PoolClient) ([]*PoolRoomInfo, error) {
+	rows, err := pool.getownedrooms_stmt.DoSelectRows(
+		[]any{
+			client.id.user_id,
+			client.id.chat_id},
+		[]variantParam{NAME_COL, STATE_COL, SETTINGS_COL, CNT_COL, LAST_USED_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolRoomInfo, 0, len(rows))
+	for _, row := range rows {
+		room, err := pool.NewPoolRoom(client, row[NAME_COL.name].(string))
+		if err != nil {
+			return nil, err
+		}
+		room.setts = GenRoomSettings(row[SETTINGS_COL.name].(string))
+		room.state = GenPoolGame(row[STATE_COL.name].(string))
+		result = append(result, &PoolRoomInfo{
+			Room:     room,
+			Members:  int(row[CNT_COL.name].(int64)),
+			LastUsed: row[LAST_USED_COL.name].(string),
+		})
+	}
+	return result, nil
+}
+
+// RenameRoom renames the room with all its records. The room can not be
+// renamed while the game is in progress
+func (pool *Pool) RenameRoom(room *PoolRoom, name string) (*PoolRoom, error) {
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return nil, err
+	}
+	if state.State == GST_STARTED {
+		return nil, ErrGameInProgress
+	}
+
+	cols, err := pool.countroom_stmt.DoSelectRow(
+		[]any{room.ownerid.user_id, room.ownerid.chat_id, name},
+		[]variantParam{CNT_COL})
+	if err != nil {
+		return nil, err
+	}
+	if cols[CNT_COL.name].(int64) > 0 {
+		return nil, ErrRoomExists
+	}
+
+	pool.stopAutoStart(room)
+
+	tx, err := pool.client_db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	err = pool.rekeyRoom(tx, []any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name,
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		name})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.GenRoom(&PoolClient{id: room.ownerid, user_name: room.ownername},
+		name, false, DefaultLocale())
+}
+
+// DeleteRoom removes the members from the room and deletes the room with
+// all its records
+func (pool *Pool) DeleteRoom(room *PoolRoom) error {
+	return pool.deleteRoom(room, UPD_ROOM_FINISHED)
+}
+
+func (pool *Pool) deleteRoom(room *PoolRoom, upd_type PoolUpdateType) error {
+	err := pool.resetRoom(room, upd_type)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pool.client_db.Begin()
+	if err != nil {
+		return err
+	}
+	bindings := []any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name}
+	// the foreign keys are not enforced so cascade the removal by hands
+	for _, stmt := range []*StmtWrapper{
+		pool.clrmembers_stmt,
+		pool.clrpending_stmt,
+		pool.delhashes_stmt,
+		pool.delbans_stmt,
+		pool.delplayers_stmt,
+		pool.clrpassattempts_stmt,
+		pool.delroom_stmt} {
+		err = stmt.DoUpdateTx(tx, bindings)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (pool *Pool) IsBanned(room *PoolRoom, client *PoolClient) (bool, error) {
+	cols, err := pool.isbanned_stmt.DoSelectRow(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			client.id.user_id},
+		[]variantParam{CNT_COL})
+	if err != nil {
+		return false, err
+	}
+	return cols[CNT_COL.name].(int64) > 0, nil
+}
+
+// GetBans returns the banned users. The clients have user ids only
+func (pool *Pool) GetBans(room *PoolRoom) ([]*PoolClient, error) {
+	ids, err := pool.getbans_stmt.DoSelectRows(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.GetName()},
+		[]variantParam{MUID_COL, USERNAME_COL})
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolClient, 0)
+	for _, id := range ids {
+		result = append(result, &PoolClient{
+			id:        TgUserId{user_id: id[MUID_COL.name].(int64)},
+			user_name: id[USERNAME_COL.name].(string),
+		})
+	}
+	return result, nil
+}
+
+func (pool *Pool) Unban(room *PoolRoom, user_id int64) error {
+	return pool.rmvban_stmt.DoUpdate(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			user_id})
+}
+
+// KickMember removes the member from the room. The banned member can
+// not join the room again until the owner unbans it
+func (pool *Pool) KickMember(room *PoolRoom, user_id int64, ban bool) error {
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return err
+	}
+	var client *PoolClient = nil
+	for _, mem := range members {
+		if mem.id.user_id == user_id {
+			client = mem
+			break
+		}
+	}
+	is_member := client != nil
+	if !is_member && !ban {
+		return ErrNotMember
+	}
+	if client != nil && room.ownerid.Compare(client.GetID()) == 0 {
+		return ErrNotMember
+	}
+
+	if ban {
+		if !is_member {
+			// the user could wait in the join queue. The ban is for the
+			// user so the requests from all the chats are removed
+			pending, err := pool.GetPendingMembers(room)
+			if err != nil {
+				return err
+			}
+			for _, mem := range pending {
+				if mem.id.user_id != user_id {
+					continue
+				}
+				client = mem
+				err = pool.removePending(room, mem)
+				if err != nil {
+					return err
+				}
+			}
+			if client == nil {
+				return ErrNotInQueue
+			}
+		}
+		err = pool.addban_stmt.DoUpdate(
+			[]any{
+				room.ownerid.user_id,
+				room.ownerid.chat_id,
+				room.name,
+				client.id.user_id,
+				client.user_name})
+		if err != nil {
+			return err
+		}
+	}
+
+	var banned int64 = 0
+	if ban {
+		banned = 1
+	}
+	upd := PoolUpdate{
+		Type:   UPD_KICKED,
+		Params: []any{client, room, banned}}
+	pool.updates <- upd
+
+	if is_member {
+		return pool.ExitRoom(room, client)
+	}
+	return nil
+}
+
+// resumeRound finishes the round if the members who left were the
+// only ones the round was waiting for
+func (pool *Pool) resumeRound(room *PoolRoom) {
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
+
+	state, err := pool.GetRoomState(room)
+	if err != nil || state.State != GST_STARTED {
+		return
+	}
+	pool.checkRoundFinished(room)
+}
+
+func (pool *Pool) RestartRoom(room *PoolRoom) error {
+	// the queued players join the new match
+	err := pool.admitPending(room)
+	if err != nil {
+		return err
+	}
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return err
+	}
+	if countPlayers(members) < room.GetRoomSettings().GetMinPlayers() {
+		return ErrNotEnoughPlayers
+	}
+	// start the new match from scratch
+	return pool.restartSession(room, &PoolGame{})
+}
+
+func (pool *Pool) QueueMember(room *PoolRoom, client *PoolClient) error {
+	err := pool.addpending_stmt.DoUpdate(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			client.id.user_id,
+			client.id.chat_id})
+	if err != nil {
+		return err
+	}
+
+	upd := PoolUpdate{
+		Type:   UPD_JOIN_QUEUED,
+		Params: []any{client, room}}
+	pool.updates <- upd
+
+	owner, err := pool.GetUser(room.ownerid)
+	if err != nil {
+		return err
+	}
+	upd = PoolUpdate{
+		Type:   UPD_MEMBER_QUEUED,
+		Params: []any{owner, room, client.user_name}}
+	pool.updates <- upd
+
+	// the queued player could complete the room for the restart
+	return pool.checkAutoStart(room)
+}
+
+func (pool *Pool) GetPendingMembers(room *PoolRoom) ([]*PoolClient, error) {
+	ids, err := pool.getpending_stmt.DoSelectRows(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.GetName()},
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL})
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolClient, 0)
+	for _, id := range ids {
+		result = append(result, &PoolClient{
+			id: TgUserId{
+				id[MUID_COL.name].(int64),
+				id[MCID_COL.name].(int64)},
+			user_name: id[USERNAME_COL.name].(string),
+			locale:    GetLocale(id[LOCALE_COL.name].(string)),
+		})
+	}
+	return result, nil
+}
+
+func (pool *Pool) getPendingMember(room *PoolRoom, id *TgUserId) (*PoolClient, error) {
+	cols, err := pool.getpendingmem_stmt.DoSelectRow(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.GetName(),
+			id.user_id,
+			id.chat_id},
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL})
+	if err == sql.ErrNoRows {
+		return nil, ErrNotInQueue
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PoolClient{
+		id: TgUserId{
+			cols[MUID_COL.name].(int64),
+			cols[MCID_COL.name].(int64)},
+		user_name: cols[USERNAME_COL.name].(string),
+		locale:    GetLocale(cols[LOCALE_COL.name].(string)),
+	}, nil
+}
+
+func (pool *Pool) removePending(room *PoolRoom, client *PoolClient) error {
+	return pool.rmvpending_stmt.DoUpdate(
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			client.id.user_id,
+			client.id.chat_id})
+}
+
+// ApprovePending lets the queued user in right now. If the game is in
+// progress the new member watches it and plays from the next session
+func (pool *Pool) ApprovePending(room *PoolRoom, id *TgUserId) error {
+	client, err := pool.getPendingMember(room, id)
+	if err != nil {
+		return err
+	}
+	err = pool.removePending(room, client)
+	if err != nil {
+		return err
+	}
+
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return err
+	}
+	if state.State == GST_WAITING {
+		return pool.AddMember(room, client)
+	}
+	return pool.addMemberWithState(room, client, MEMBER_PLAYER, "{\"state\":1}")
+}
+
+func (pool *Pool) DropPending(room *PoolRoom, id *TgUserId) error {
+	client, err := pool.getPendingMember(room, id)
+	if err != nil {
+		return err
+	}
+	err = pool.removePending(room, client)
+	if err != nil {
+		return err
+	}
+
+	upd := PoolUpdate{
+		Type:   UPD_JOIN_DROPPED,
+		Params: []any{client, room}}
+	pool.updates <- upd
+	return nil
+}
+
+func (pool *Pool) admitPending(room *PoolRoom) error {
+	pending, err := pool.GetPendingMembers(room)
+	if err != nil {
+		return err
+	}
+	for _, client := range pending {
+		full, err := pool.isRoomFull(room, client)
+		if err != nil {
+			return err
+		}
+		if full {
+			// the rest wait for the free places
+			break
+		}
+		err = pool.removePending(room, client)
+		if err != nil {
+			return err
+		}
+		err = pool.AddMember(room, client)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pool *Pool) restartSession(room *PoolRoom, match *PoolGame) error {
+	if room != nil {
+		state := &PoolGame{
+			State:   GST_ROOM_CLOSED_WAIT_TO_START,
+			Session: match.Session + 1,
+			Wins:    match.Wins}
+
+		err := pool.UpdateRoomState(room, state)
+		if err != nil {
+			return err
+		}
+		err = pool.ResetMembersState(room)
+		if err != nil {
+			return err
+		}
+		err = pool.initMembersLives(room)
+		if err != nil {
+			return err
+		}
+		// send "room closed" event
+		err = pool.forEachMemberDo(room, func(room_ *PoolRoom, mem_ *PoolClient, params_ []any) error {
+			upd := PoolUpdate{
+				Type:   UPD_ROOM_CLOSED,
+				Params: []any{mem_, room_}}
+			pool.updates <- upd
+			return nil
+		}, []any{})
+		if err == sql.ErrNoRows {
+			return ErrNoActiveRooms
+		}
+		if err != nil {
+			return err
+		}
+		// init game
+		// give turn to the first player
+		state, err = pool.GetRoomState(room)
+		if err != nil {
+			return err
+		}
+		state.Round = 0
+		err = pool.UpdateRoomState(room, state)
+		if err != nil {
+			return err
+		}
+		return pool.NextRound(room)
+	}
+	return nil
+}
+
+func (pool *Pool) CloseRoom(room *PoolRoom) error {
+	if room != nil {
+		state, err := pool.GetRoomState(room)
+		if err != nil {
+			return err
+		}
+
+		if state.State != GST_WAITING {
+			return ErrAlreadyClosed
+		}
+
+		return pool.RestartRoom(room)
+	}
+	return nil
+}
+
+func (pool *Pool) NotifyOwnerFinishedGame(room *PoolRoom) error {
+	if room.ownerid.IsLobby() {
+		// nobody restarts the matchmaking rooms
+		pool.closeLobbyRoom(room)
+		return nil
+	}
+
+	owner, err := pool.GetUser(room.ownerid)
+	if err != nil {
+		return err
+	}
+	upd := PoolUpdate{
+		Type:   UPD_SESSION_FINISHED,
+		Params: []any{owner, room}}
+	pool.updates <- upd
+
+	err = pool.checkAutoStart(room)
+	if err != nil {
+		return err
+	}
+
+	// the queued players are waiting for the restart
+	pending, err := pool.GetPendingMembers(room)
+	if err != nil {
+		return err
+	}
+	for _, client := range pending {
+		upd := PoolUpdate{
+			Type:   UPD_QUEUE_SESSION_FINISHED,
+			Params: []any{client, room}}
+		pool.updates <- upd
+	}
+	return nil
+}
+
+func (pool *Pool) NextRound(room *PoolRoom) error {
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return err
+	}
+
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return err
+	}
+
+	state.State = GST_STARTED
+	state.Round++
+	state.Deadline = 0
+
+	timeout := 0
+	if room.GetRoomSettings() != nil {
+		timeout = room.GetRoomSettings().TurnTimeout
+	}
+	if timeout > 0 {
+		state.Deadline = time.Now().Add(time.Duration(timeout) * time.Second).Unix()
+	}
+
+	humans := 0
+	for _, mem := range members {
+		if !mem.GetID().IsAI() {
+			humans++
+		}
+	}
+	if state.Round == 1 && humans > 0 {
+		err = pool.recordGame(room, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pool.UpdateRoomState(room, state)
+	if err != nil {
+		return err
+	}
+
+	if humans == 0 {
+		// nobody to play with the AI members
+		state.State = GST_ROOM_CLOSED_WAIT_TO_START
+		state.Deadline = 0
+		err = pool.UpdateRoomState(room, state)
+		if err != nil {
+			return err
+		}
+		return pool.NotifyOwnerFinishedGame(room)
+	}
+
+	err = pool.recordRound(state)
+	if err != nil {
+		return err
+	}
+
+	pool.scheduleTurnTimers(room, state.Round, timeout)
+
+	commit_reveal := room.GetRoomSettings() != nil && room.GetRoomSettings().CommitReveal
+
+	ai_members := make([]*PoolClient, 0)
+	for _, mem := range members {
+		mem.player.Choose = 0
+		mem.player.Skip = false
+		mem.player.Salt = ""
+		mem.player.Commit = ""
+		mem.player.Planned = 0
+		if commit_reveal && mem.player.State == PST_PLAYING {
+			// the server decides some moves in advance to commit them
+			salt, err := GenSalt()
+			if err != nil {
+				return err
+			}
+			mem.player.Salt = salt
+			if mem.GetID().IsAI() {
+				mem.player.Planned = pool.chooseAIMove(room, pool.GetAIStrategy(mem), state.Round, members)
+			} else if timeout > 0 && room.GetRoomSettings().TimeoutPolicy == TIMEOUT_RANDOM {
+				mem.player.Planned = aiRandomMove(room.GetRules())
+			}
+			if mem.player.Planned != 0 {
+				mem.player.Commit = Commitment(state.Round, CommitmentUID(mem.GetID()), mem.player.Planned, mem.player.Salt)
+			}
+		}
+		err := pool.UpdateMemberState(room, mem, mem.player)
+		if err != nil {
+			return err
+		}
+		if mem.GetID().IsAI() {
+			if mem.player.State == PST_PLAYING {
+				ai_members = append(ai_members, mem)
+			}
+		} else if mem.player.State == PST_PLAYING {
+			upd := PoolUpdate{
+				Type:   UPD_YOUR_TURN,
+				Params: []any{mem, room, int64(state.Round)}}
+			pool.updates <- upd
+		} else {
+			upd := PoolUpdate{
+				Type:   UPD_WAIT_FOR_TURN,
+				Params: []any{mem, int64(state.Round)}}
+			pool.updates <- upd
+		}
+	}
+
+	// the AI members make their moves when all states are ready
+	for _, mem := range ai_members {
+		go pool.playAI(room, mem, state.Round)
+	}
+
+	return nil
+}
+
+/* important! do not call recursively */
+func (pool *Pool) UpdateMemberChoose(
+	client *PoolClient,
+	room *PoolRoom,
+	round int64,
+	choose int) error {
+
+	if (round < 1) || (round > 255) {
+		// the owner could hand the room over on exit, that takes the lock
+		return pool.ExitRoom(room, client)
+	}
+
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
+
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return err
+	}
+
+	if state.State != GST_STARTED {
+		return ThrowRoomNotReady(client.GetLocale())
+	}
+
+	if round != int64(state.Round) {
+		return ErrStaleRound
+	}
+
+	if !room.GetRules().IsValid(choose) {
+		return ThrowRoomNotReady(client.GetLocale())
+	}
+
+	mem_state, err := pool.GetMemberState(room, client)
+	if err != nil {
+		return err
+	}
+
+	if len(mem_state.Salt) > 0 && mem_state.Choose != 0 {
+		// the committed choice can not be changed until the reveal. The
+		// commitment of the planned move is replaced with the first vote
+		return ErrChoiceCommitted
+	}
+
+	mem_state.Choose = choose
+	chooses := make([]int, round)
+	if mem_state.Chooses != nil && len(mem_state.Chooses) > 0 {
+		copy(chooses, mem_state.Chooses)
+	}
+	chooses[round-1] = choose
+	mem_state.Chooses = chooses
+
+	if len(mem_state.Salt) > 0 {
+		mem_state.Commit = Commitment(int(round), CommitmentUID(client.GetID()), choose, mem_state.Salt)
+	}
+
+	err = pool.UpdateMemberState(room, client, mem_state)
+	if err != nil {
+		return err
+	}
+	err = pool.recordMove(room, state, client, choose)
+	if err != nil {
+		return err
+	}
+
+	if len(mem_state.Commit) > 0 {
+		upd := PoolUpdate{
+			Type:   UPD_CHOICE_COMMITTED,
+			Params: []any{client, room, int64(choose), mem_state.Commit}}
+		pool.updates <- upd
+	}
+
+	return pool.checkRoundFinished(room)
+}
+
+/* important! call only with choose_mux locked */
+func (pool *Pool) checkRoundFinished(room *PoolRoom) error {
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return err
+	}
+
+	// check is round finished (all voted)
+	var all_finish bool = true
+	var all_skip bool = true
+	var vote int64 = 0
+	var counts map[int64]int = make(map[int64]int)
+
+	for _, mem := range members {
+		if mem.player.State == PST_PLAYING && !mem.player.Skip {
+			if mem.player.Choose == 0 {
+				all_finish = false
+			} else {
+				counts[int64(mem.player.Choose)]++
+			}
+			all_skip = false
+			vote |= int64(mem.player.Choose)
+		}
+	}
+
+	if all_finish {
+		pool.stopTurnTimers(room)
+
+		state, err := pool.GetRoomState(room)
+		if err != nil {
+			return err
+		}
+		state.State = GST_ROOM_CLOSED_WAIT_TO_START
+		state.Deadline = 0
+
+		if room.GetRoomSettings().GetMode() == MODE_SCORING {
+			return pool.finishScoringRound(room, state, members)
+		}
+
+		// winner is the mask of the surviving gestures
+		var winner int64 = room.GetRules().Winner(vote)
+		var draw_strategy int64 = DRAW_NONE
+
+		if winner == 0 && len(counts) > 1 {
+			state.Draws++
+			setts := room.GetRoomSettings()
+			if setts != nil && setts.DrawStrategy != DRAW_NONE {
+				winner = ResolveDraw(setts.DrawStrategy, counts, state.Draws, setts.GetDrawLimit())
+				if winner != 0 {
+					draw_strategy = int64(setts.DrawStrategy)
+				}
+			}
+		}
+		if winner != 0 {
+			state.Draws = 0
+		}
+		err = pool.UpdateRoomState(room, state)
+		if err != nil {
+			return err
+		}
+		err = pool.recordRoundResult(room, state, winner, draw_strategy)
+		if err != nil {
+			return err
+		}
+
+		var playing_now int = 0
+		var winner_mem *PoolClient = nil
+		var prev_states []int64 = make([]int64, len(members))
+
+		for i, mem := range members {
+			prev_states[i] = int64(mem.player.State)
+
+			if prev_states[i] == PST_PLAYING && !mem.player.Skip {
+				if int64(mem.player.Choose)&winner == 0 && winner != 0 {
+					err = pool.loseRound(state, mem)
+					if err != nil {
+						return err
+					}
+					err = pool.UpdateMemberState(room, mem, mem.player)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if mem.player.State == PST_PLAYING {
+				playing_now++
+				winner_mem = mem
+			}
+		}
+
+		session_finished := playing_now <= 1 || all_skip
+		winners := make([]*PoolClient, 0, 1)
+		if session_finished && playing_now == 1 && countPlayers(members) > 1 {
+			winners = append(winners, winner_mem)
+		}
+
+		var changes map[TgUserId]PoolRatingChange = nil
+		var match_finished bool = false
+		if session_finished {
+			// the survivors beat all the others and the players
+			// eliminated later beat the ones eliminated earlier
+			losers := eliminatedMembers(members)
+			changes, match_finished, err = pool.commitSession(room, state, members, winners, losers, func(mem *PoolClient) int {
+				if mem.player.State == PST_PLAYING {
+					return state.Round + 1
+				}
+				return mem.player.Eliminated
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			// the statistics of the eliminated players are written
+			// with the result of the session
+			err = pool.UpdateRoomState(room, state)
+			if err != nil {
+				return err
+			}
+		}
+
+		reveals := collectReveals(members, state.Round)
+		for i, mem := range members {
+			upd := PoolUpdate{
+				Type:   UPD_ROUND_FINISHED,
+				Params: []any{mem, room, winner, prev_states[i], state, draw_strategy, reveals, changes}}
+			pool.updates <- upd
+		}
+
+		if session_finished {
+			return pool.finishSession(room, state, members, winners, match_finished)
+		} else {
+			return pool.NextRound(room)
+		}
+	}
+
+	return nil
+}
+
+/* important! call only with choose_mux locked */
+func (pool *Pool) finishScoringRound(room *PoolRoom, state *PoolGame, members []*PoolClient) error {
+	rules := room.GetRules()
+	voted := func(mem *PoolClient) bool {
+		return mem.player.State == PST_PLAYING && !mem.player.Skip && mem.player.Choose != 0
+	}
+
+	// every player earns a point per opponent beaten
+	for _, mem := range members {
+		mem.player.Points = 0
+		if voted(mem) {
+			gesture := rules.GetGesture(mem.player.Choose)
+			for _, other := range members {
+				if other != mem && voted(other) && gesture.Beats&other.player.Choose != 0 {
+					mem.player.Points++
+				}
+			}
+			mem.player.Score += mem.player.Points
+		}
+		err := pool.UpdateMemberState(room, mem, mem.player)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := pool.recordRoundResult(room, state, 0, DRAW_NONE)
+	if err != nil {
+		return err
+	}
+
+	session_finished := state.Round >= room.GetRoomSettings().GetRounds()
+	winners := make([]*PoolClient, 0)
+	var changes map[TgUserId]PoolRatingChange = nil
+	var match_finished bool = false
+	if session_finished {
+		best := -1
+		for _, mem := range members {
+			if mem.player.State != PST_PLAYING {
+				continue
+			}
+			if mem.player.Score > best {
+				best = mem.player.Score
+				winners = winners[:0]
+			}
+			if mem.player.Score == best {
+				winners = append(winners, mem)
+			}
+		}
+		if countPlayers(members) <= 1 {
+			winners = winners[:0]
 		}
-		if err != nil {
-			return err
+		losers := eliminatedMembers(members)
+		for _, mem := range members {
+			if mem.player.State == PST_PLAYING && !slices.Contains(winners, mem) {
+				losers = append(losers, mem)
+			}
 		}
-		// init game
-		// give turn to the first player
-		state, err = pool.GetRoomState(room)
+		changes, match_finished, err = pool.commitSession(room, state, members, winners, losers, func(mem *PoolClient) int {
+			if mem.player.State != PST_PLAYING {
+				// the players eliminated by the timeouts are behind all the others
+				return -1
+			}
+			return mem.player.Score
+		})
 		if err != nil {
 			return err
 		}
-		state.Round = 0
+	} else {
 		err = pool.UpdateRoomState(room, state)
 		if err != nil {
 			return err
 		}
-		return pool.NextRound(room)
 	}
-	return nil
+
+	reveals := collectReveals(members, state.Round)
+	for _, mem := range members {
+		upd := PoolUpdate{
+			Type:   UPD_ROUND_FINISHED,
+			Params: []any{mem, room, int64(0), int64(mem.player.State), state, int64(DRAW_NONE), reveals, changes}}
+		pool.updates <- upd
+	}
+
+	if session_finished {
+		return pool.finishSession(room, state, members, winners, match_finished)
+	}
+	return pool.NextRound(room)
 }
 
-func (pool *Pool) CloseRoom(room *PoolRoom) error {
-	if room != nil {
-		state, err := pool.GetRoomState(room)
-		if err != nil {
-			return err
-		}
+// addMatchWin counts the session win for the single winner and
+// returns true if the match is finished
+func (pool *Pool) addMatchWin(room *PoolRoom, state *PoolGame, winners []*PoolClient) bool {
+	switch len(winners) {
+	case 0:
+		return true
+	case 1:
+		wins := state.AddWin(winners[0].GetID())
+		return wins >= room.GetRoomSettings().GetMatchWins()
+	}
+	// the tie - nobody gets the win
+	return false
+}
 
-		if state.State != GST_WAITING {
-			return ErrAlreadyClosed
-		}
+/* important! call only with choose_mux locked */
+func (pool *Pool) finishSession(room *PoolRoom, state *PoolGame, members []*PoolClient, winners []*PoolClient, match_finished bool) error {
+	// the statistics of the players are written with commitSession
+	for _, winner_mem := range winners {
+		upd := PoolUpdate{
+			Type:   UPD_YOU_WIN,
+			Params: []any{winner_mem, room}}
+		pool.updates <- upd
+	}
 
-		return pool.RestartRoom(room)
+	if room.GetRoomSettings().GetMatchWins() > 1 {
+		if !match_finished {
+			// start the next session of the match
+			return pool.restartSession(room, state)
+		}
+		var winner_mem *PoolClient = nil
+		if len(winners) == 1 {
+			winner_mem = winners[0]
+		}
+		for _, mem := range members {
+			upd := PoolUpdate{
+				Type:   UPD_MATCH_FINISHED,
+				Params: []any{mem, room, winner_mem, state}}
+			pool.updates <- upd
+		}
 	}
-	return nil
+
+	return pool.NotifyOwnerFinishedGame(room)
 }
 
-func (pool *Pool) NotifyOwnerFinishedGame(room *PoolRoom) error {
-	owner, err := pool.GetUser(room.ownerid)
-	if err != nil {
-		return err
+func (pool *Pool) stopTurnTimers(room *PoolRoom) {
+	pool.timers_mux.Lock()
+	defer pool.timers_mux.Unlock()
+
+	for _, timer := range pool.timers[room.key()] {
+		timer.Stop()
 	}
-	upd := PoolUpdate{
-		Type:   UPD_SESSION_FINISHED,
-		Params: []any{owner, room}}
-	pool.updates <- upd
-	return nil
+	delete(pool.timers, room.key())
 }
 
-func (pool *Pool) NextRound(room *PoolRoom) error {
+// resumeTurn restarts the turn timers and the pending AI moves of the
+// started game by its deadline
+func (pool *Pool) resumeTurn(room *PoolRoom) error {
+	state := room.GetGame()
+	if state.State != GST_STARTED {
+		return nil
+	}
+	if state.Deadline > 0 {
+		left := state.Deadline - time.Now().Unix()
+		if left < 1 {
+			left = 1
+		}
+		pool.scheduleTurnTimers(room, state.Round, int(left))
+	}
 	members, err := pool.GetMembers(room)
 	if err != nil {
 		return err
 	}
-
-	state, err := pool.GetRoomState(room)
-	if err != nil {
-		return err
+	for _, mem := range members {
+		if mem.GetID().IsAI() && mem.player.State == PST_PLAYING && mem.player.Choose == 0 {
+			go pool.playAI(room, mem, state.Round)
+		}
 	}
+	return nil
+}
 
-	state.State = GST_STARTED
-	state.Round++
-
-	err = pool.UpdateRoomState(room, state)
+// resumeGames resumes the games that were started before the restart
+func (pool *Pool) resumeGames() error {
+	rooms, err := pool.getRooms(pool.getstartedrooms_stmt, []any{GST_STARTED})
 	if err != nil {
 		return err
 	}
-
-	for _, mem := range members {
-		mem.player.Choose = 0
-		err := pool.UpdateMemberState(room, mem, mem.player)
+	for _, room := range rooms {
+		err = pool.resumeTurn(room)
 		if err != nil {
 			return err
 		}
-		if mem.player.State == PST_PLAYING {
-			upd := PoolUpdate{
-				Type:   UPD_YOUR_TURN,
-				Params: []any{mem, room, int64(state.Round)}}
-			pool.updates <- upd
-		} else {
-			upd := PoolUpdate{
-				Type:   UPD_WAIT_FOR_TURN,
-				Params: []any{mem, int64(state.Round)}}
-			pool.updates <- upd
-		}
 	}
-
 	return nil
 }
 
-/* important! do not call recursively */
-func (pool *Pool) UpdateMemberChoose(
-	client *PoolClient,
-	room *PoolRoom,
-	round int64,
-	choose int) error {
-
-	pool.choose_mux.Lock()
-	defer pool.choose_mux.Unlock()
+func (pool *Pool) scheduleTurnTimers(room *PoolRoom, round int, timeout int) {
+	pool.stopTurnTimers(room)
 
-	state, err := pool.GetRoomState(room)
-	if err != nil {
-		return err
+	if timeout <= 0 {
+		return
 	}
 
-	if state.State != GST_STARTED {
-		return ThrowRoomNotReady(client.GetLocale())
+	// warn the slow players shortly before the deadline
+	warn_before := timeout / 3
+	if warn_before > 15 {
+		warn_before = 15
 	}
 
-	if (round < 1) || (round > 255) {
-		return pool.ExitRoom(room, client)
-	}
+	pool.timers_mux.Lock()
+	defer pool.timers_mux.Unlock()
 
-	mem_state, err := pool.GetMemberState(room, client)
-	if err != nil {
-		return err
+	pool.timers[room.key()] = []*time.Timer{
+		time.AfterFunc(time.Duration(timeout-warn_before)*time.Second, func() {
+			pool.onTurnWarning(room, round, warn_before)
+		}),
+		time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			pool.onTurnTimeout(room, round)
+		}),
 	}
+}
 
-	mem_state.Choose = choose
-	chooses := make([]int, round)
-	if mem_state.Chooses != nil && len(mem_state.Chooses) > 0 {
-		copy(chooses, mem_state.Chooses)
-	}
-	chooses[round-1] = choose
-	mem_state.Chooses = chooses
+func (pool *Pool) onTurnWarning(room *PoolRoom, round int, left int) {
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
 
-	err = pool.UpdateMemberState(room, client, mem_state)
-	if err != nil {
-		return err
+	state, err := pool.GetRoomState(room)
+	if err != nil || state.State != GST_STARTED || state.Round != round {
+		return
 	}
 
 	members, err := pool.GetMembers(room)
 	if err != nil {
-		return err
+		return
 	}
 
-	// check is round finished (all voted)
-	var all_finish bool = true
-	var vote int64 = 0
-
 	for _, mem := range members {
-		if mem.player.State == PST_PLAYING {
-			if mem.player.Choose == 0 {
-				all_finish = false
-			}
-			vote |= int64(mem.player.Choose)
+		if mem.player.State == PST_PLAYING && mem.player.Choose == 0 {
+			upd := PoolUpdate{
+				Type:   UPD_TURN_WARNING,
+				Params: []any{mem, room, int64(left)}}
+			pool.updates <- upd
 		}
 	}
+}
 
-	if all_finish {
-		state, err := pool.GetRoomState(room)
-		if err != nil {
-			return err
-		}
-		state.State = GST_ROOM_CLOSED_WAIT_TO_START
-		err = pool.UpdateRoomState(room, state)
-		if err != nil {
-			return err
-		}
-
-		var winner int64
+func (pool *Pool) onTurnTimeout(room *PoolRoom, round int) {
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
 
-		switch vote {
-		case 3:
-			winner = 1
-		case 5:
-			winner = 4
-		case 6:
-			winner = 2
-		default:
-			winner = 0 // nobody wins
-		}
+	state, err := pool.GetRoomState(room)
+	if err != nil || state.State != GST_STARTED || state.Round != round {
+		return
+	}
 
-		var playing_now int = 0
-		var winner_mem *PoolClient = nil
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return
+	}
 
-		for _, mem := range members {
-			var a_state int64 = int64(mem.player.State)
+	rules := room.GetRules()
+	for _, mem := range members {
+		if mem.player.State != PST_PLAYING || mem.player.Choose != 0 {
+			continue
+		}
 
-			if a_state == PST_PLAYING {
-				if mem.player.Choose != int(winner) && winner != 0 {
-					mem.player.State = PST_WATCHING
-					err = pool.UpdateMemberState(room, mem, mem.player)
-					if err != nil {
-						return err
-					}
-					err = pool.incClientStat(mem, loss)
-					if err != nil {
-						return err
-					}
+		switch room.GetRoomSettings().TimeoutPolicy {
+		case TIMEOUT_RANDOM:
+			{
+				choose := mem.player.Planned
+				if choose == 0 {
+					choose = aiRandomMove(rules)
+				} else if len(mem.player.Salt) > 0 {
+					mem.player.Commit = Commitment(round, CommitmentUID(mem.GetID()), choose, mem.player.Salt)
 				}
+				chooses := make([]int, round)
+				copy(chooses, mem.player.Chooses)
+				chooses[round-1] = choose
+				mem.player.Choose = choose
+				mem.player.Chooses = chooses
+				err = pool.recordMove(room, state, mem, choose)
 			}
-
-			if mem.player.State == PST_PLAYING {
-				playing_now++
-				winner_mem = mem
-			}
-
-			upd := PoolUpdate{
-				Type:   UPD_ROUND_FINISHED,
-				Params: []any{mem, room, winner, a_state}}
-			pool.updates <- upd
-		}
-
-		if playing_now <= 1 {
-			if len(members) > 1 {
-				if winner_mem != nil {
-					err = pool.incClientStat(winner_mem, won)
-					if err != nil {
-						return err
-					}
-					upd := PoolUpdate{
-						Type:   UPD_YOU_WIN,
-						Params: []any{winner_mem, room}}
-					pool.updates <- upd
+		case TIMEOUT_SKIP:
+			mem.player.Skip = true
+			err = pool.recordMove(room, state, mem, 0)
+		default:
+			{
+				// the missed turn is the lost round
+				err = pool.loseRound(state, mem)
+				if err == nil && mem.player.State == PST_PLAYING {
+					// the player with the lives left waits for the next round
+					mem.player.Skip = true
+					err = pool.recordMove(room, state, mem, 0)
 				}
 			}
+		}
+		if err != nil {
+			return
+		}
 
-			return pool.NotifyOwnerFinishedGame(room)
-		} else {
-			return pool.NextRound(room)
+		err = pool.UpdateMemberState(room, mem, mem.player)
+		if err != nil {
+			return
 		}
 	}
 
-	return nil
+	pool.checkRoundFinished(room)
 }
 
 func (pool *Pool) setClientSettingsValue(client *PoolClient, sett *PoolClientSettings, value string) error {
@@ -1477,33 +4122,29 @@ func (pool *Pool) updateClientSettings(client *PoolClient, sett *PoolClientSetti
 	return err
 }
 
-type gameResult int
-
-const (
-	won gameResult = iota
-	loss
-)
+// loseRound takes one life of the member or eliminates the member when
+// it was the last one. The member state is not saved
+func (pool *Pool) loseRound(state *PoolGame, mem *PoolClient) error {
+	if mem.player.Lives > 1 {
+		// the lost round costs one life only
+		mem.player.Lives--
+		return nil
+	}
+	mem.player.Lives = 0
+	mem.player.State = PST_WATCHING
+	mem.player.Eliminated = state.Round
+	return pool.recordEliminated(state, mem)
+}
 
-func (pool *Pool) incClientStat(client *PoolClient, res gameResult) error {
-	switch res {
-	case won:
-		{
-			err := pool.incuserstatw_stmt.DoUpdate(
-				[]any{
-					client.id.user_id,
-					client.id.chat_id})
-			return err
-		}
-	case loss:
-		{
-			err := pool.incuserstatt_stmt.DoUpdate(
-				[]any{
-					client.id.user_id,
-					client.id.chat_id})
-			return err
+// eliminatedMembers returns the players eliminated during the session
+func eliminatedMembers(members []*PoolClient) []*PoolClient {
+	losers := make([]*PoolClient, 0)
+	for _, mem := range members {
+		if !mem.player.Spectator && mem.player.Eliminated > 0 {
+			losers = append(losers, mem)
 		}
 	}
-	return nil
+	return losers
 }
 
 func (pool *Pool) GetMemberState(room *PoolRoom, client *PoolClient) (*PoolPlayer, error) {
@@ -1578,6 +4219,26 @@ func (pool *Pool) updateClientRoomSettings(client *PoolClient, room string, sett
 	return err
 }
 
+func (pool *Pool) UpdateRoomSettings(room *PoolRoom, sett *PoolRoomSettings) error {
+	state, err := pool.GetRoomState(room)
+	if err != nil {
+		return err
+	}
+
+	// the settings are changed only between the games
+	if state.State != GST_WAITING && state.State != GST_ROOM_CLOSED_WAIT_TO_START {
+		return ErrAlreadyClosed
+	}
+
+	err = pool.updateClientRoomSettings(&PoolClient{id: room.ownerid, locale: DefaultLocale()}, room.name, sett)
+	if err != nil {
+		return err
+	}
+	sett.compileRules()
+	room.setts = sett
+	return nil
+}
+
 func (pool *Pool) GetRoomState(room *PoolRoom) (*PoolGame, error) {
 	cols, err := pool.getroomstate_stmt.DoSelectRow(
 		[]any{
@@ -1610,6 +4271,21 @@ func (pool *Pool) UpdateRoomState(room *PoolRoom, state *PoolGame) error {
 	return err
 }
 
+func (pool *Pool) updateRoomStateTx(tx *sql.Tx, room *PoolRoom, state *PoolGame) error {
+
+	json_str, err := json.Marshal(*state)
+	if err != nil {
+		return err
+	}
+
+	return pool.updroomstate_stmt.DoUpdateTx(tx,
+		[]any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			string(json_str)})
+}
+
 func (pool *Pool) ResetMembersState(room *PoolRoom) error {
 	err := pool.resetmemberst_stmt.DoUpdate(
 		[]any{
@@ -1619,19 +4295,71 @@ func (pool *Pool) ResetMembersState(room *PoolRoom) error {
 	return err
 }
 
+func (pool *Pool) initMembersLives(room *PoolRoom) error {
+	lives := room.GetRoomSettings().GetLives()
+	if lives <= 1 || room.GetRoomSettings().GetMode() != MODE_ELIMINATION {
+		return nil
+	}
+
+	members, err := pool.GetMembers(room)
+	if err != nil {
+		return err
+	}
+	for _, mem := range members {
+		if mem.player.Spectator {
+			continue
+		}
+		mem.player.Lives = lives
+		err = pool.UpdateMemberState(room, mem, mem.player)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type BufferReader struct {
 	name string
 	id   int64
 	data *bytes.Buffer
+	// the stream is uploaded instead of the data if set
+	stream *io.PipeReader
+}
+
+// NewStreamReader returns the file which content is written by the write
+// func while the file is uploaded. So the content is never kept in the
+// memory entirely. The reader should be closed after the upload
+func NewStreamReader(name string, id int64, write func(w io.Writer) error) *BufferReader {
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		err := write(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return &BufferReader{name: name, id: id, stream: pr}
 }
 
 func (reader *BufferReader) IsEmpty() bool {
+	if reader.stream != nil {
+		return false
+	}
 	if reader.data == nil {
 		return true
 	}
 	return reader.data.Len() == 0
 }
 
+// Close stops the writer of the stream if the upload is not finished
+func (reader *BufferReader) Close() error {
+	if reader.stream != nil {
+		return reader.stream.Close()
+	}
+	return nil
+}
+
 func (reader *BufferReader) GetId() int64 {
 	return reader.id
 }
@@ -1644,6 +4372,9 @@ func (reader *BufferReader) NeedsUpload() bool {
 // UploadData gets the file name and an `io.Reader` for the file to be uploaded. This
 // must only be called when the file needs to be uploaded.
 func (reader *BufferReader) UploadData() (string, io.Reader, error) {
+	if reader.stream != nil {
+		return reader.name, reader.stream, nil
+	}
 	return reader.name, reader.data, nil
 }
 
@@ -0,0 +1,34 @@
+/*===============================================================*/
+/* The SPS Bot (client pool tests)                               */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenRoomToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		token, err := GenRoomToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != ROOM_TOKEN_LEN {
+			t.Fatalf("got the token %q of length %d, want %d", token, len(token), ROOM_TOKEN_LEN)
+		}
+		for _, c := range token {
+			if !strings.ContainsRune(ROOM_TOKEN_ALPHABET, c) {
+				t.Fatalf("the token %q has the symbol %q out of the alphabet", token, c)
+			}
+		}
+		if seen[token] {
+			t.Fatalf("the token %q is repeated", token)
+		}
+		seen[token] = true
+	}
+}
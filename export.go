@@ -0,0 +1,251 @@
+/*===============================================================*/
+/* The SPS Bot (history export)                                  */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const EXPORT_CSV = "csv"
+const EXPORT_JSON = "json"
+
+// the number of moves read from the database at once. The database is not
+// locked between the chunks while the file is uploaded
+const EXPORT_CHUNK_SIZE = 500
+
+var ErrUnknownExportFormat error = fmt.Errorf("unknown export format")
+
+type PoolGestureFreq struct {
+	Sign  string `json:"sign"`
+	Count int    `json:"count"`
+}
+
+type PoolExportStat struct {
+	Total    int     `json:"total"`
+	Won      int     `json:"won"`
+	Lost     int     `json:"lost"`
+	MatchWon int     `json:"match_won"`
+	Rating   float64 `json:"rating"`
+	RD       float64 `json:"rating_rd"`
+}
+
+// PoolExportMove is the move of the player with its game and round
+type PoolExportMove struct {
+	GameId      int64  `json:"game_id"`
+	RoomName    string `json:"room"`
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at"`
+	Round       int    `json:"round"`
+	Sign        string `json:"sign"`
+	Skip        bool   `json:"skip"`
+	Eliminated  bool   `json:"eliminated"`
+	Won         bool   `json:"won"`
+	WinnerSigns string `json:"round_winner"`
+}
+
+var EXPORT_MOVE_HEADER = []string{"game_id", "room", "started_at", "finished_at",
+	"round", "sign", "skip", "eliminated", "won", "round_winner"}
+
+func (move *PoolExportMove) toCSV() []string {
+	return []string{
+		strconv.FormatInt(move.GameId, 10),
+		move.RoomName,
+		move.StartedAt,
+		move.FinishedAt,
+		strconv.Itoa(move.Round),
+		move.Sign,
+		strconv.FormatBool(move.Skip),
+		strconv.FormatBool(move.Eliminated),
+		strconv.FormatBool(move.Won),
+		move.WinnerSigns,
+	}
+}
+
+func IsExportFormat(format string) bool {
+	return format == EXPORT_CSV || format == EXPORT_JSON
+}
+
+func (pool *Pool) getExportStat(client *PoolClient) (*PoolExportStat, error) {
+	total, won, match_won, err := pool.GetUserStat(&client.id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	rating, err := pool.GetUserRating(&client.id)
+	if err != nil {
+		return nil, err
+	}
+	return &PoolExportStat{
+		Total:    total,
+		Won:      won,
+		Lost:     total - won,
+		MatchWon: match_won,
+		Rating:   rating.Rating,
+		RD:       rating.RD,
+	}, nil
+}
+
+// GetGestureFreqs returns how many times the client has shown every gesture
+func (pool *Pool) GetGestureFreqs(client *PoolClient) ([]PoolGestureFreq, error) {
+	rows, err := pool.getgesturefreqs_stmt.DoSelectRows(
+		[]any{client.id.user_id, client.id.chat_id},
+		[]variantParam{SIGN_COL, CNT_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]PoolGestureFreq, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, PoolGestureFreq{
+			Sign:  row[SIGN_COL.name].(string),
+			Count: int(row[CNT_COL.name].(int64)),
+		})
+	}
+	return result, nil
+}
+
+// forEachExportMove reads the moves of the client chunk by chunk
+func (pool *Pool) forEachExportMove(client *PoolClient, do func(move *PoolExportMove) error) error {
+	var last int64 = 0
+	for {
+		rows, err := pool.getexportmoves_stmt.DoSelectRows(
+			[]any{client.id.user_id, client.id.chat_id, last, EXPORT_CHUNK_SIZE},
+			[]variantParam{ID_COL, GAME_ID_COL, ROOMNAME_COL, STARTED_AT_COL, FINISHED_AT_COL,
+				ROUND_COL, SIGN_COL, SKIP_COL, ELIMINATED_COL, WON_COL, WINNER_SIGNS_COL})
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			last = row[ID_COL.name].(int64)
+			err = do(&PoolExportMove{
+				GameId:      row[GAME_ID_COL.name].(int64),
+				RoomName:    row[ROOMNAME_COL.name].(string),
+				StartedAt:   row[STARTED_AT_COL.name].(string),
+				FinishedAt:  row[FINISHED_AT_COL.name].(string),
+				Round:       int(row[ROUND_COL.name].(int64)),
+				Sign:        row[SIGN_COL.name].(string),
+				Skip:        row[SKIP_COL.name].(int64) != 0,
+				Eliminated:  row[ELIMINATED_COL.name].(int64) != 0,
+				Won:         row[WON_COL.name].(int64) != 0,
+				WinnerSigns: row[WINNER_SIGNS_COL.name].(string),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(rows) < EXPORT_CHUNK_SIZE {
+			return nil
+		}
+	}
+}
+
+// writeExportCSV writes the sections of the stats, the gestures and the
+// moves separated with the empty lines
+func (pool *Pool) writeExportCSV(client *PoolClient, stat *PoolExportStat, freqs []PoolGestureFreq, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"stat", "value"},
+		{"total", strconv.Itoa(stat.Total)},
+		{"won", strconv.Itoa(stat.Won)},
+		{"lost", strconv.Itoa(stat.Lost)},
+		{"match_won", strconv.Itoa(stat.MatchWon)},
+		{"rating", fmt.Sprintf("%.0f", stat.Rating)},
+		{"rating_rd", fmt.Sprintf("%.0f", stat.RD)},
+		nil,
+		{"sign", "count"},
+	}
+	for _, freq := range freqs {
+		records = append(records, []string{freq.Sign, strconv.Itoa(freq.Count)})
+	}
+	records = append(records, nil, EXPORT_MOVE_HEADER)
+	for _, record := range records {
+		// the empty record is the empty line
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	err := pool.forEachExportMove(client, func(move *PoolExportMove) error {
+		return cw.Write(move.toCSV())
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeExportJSON writes the moves one by one, so the whole document is
+// never built in the memory
+func (pool *Pool) writeExportJSON(client *PoolClient, stat *PoolExportStat, freqs []PoolGestureFreq, w io.Writer) error {
+	head, err := json.Marshal(map[string]any{
+		"user":     client.user_name,
+		"stats":    stat,
+		"gestures": freqs,
+	})
+	if err != nil {
+		return err
+	}
+	// open the object to append the moves
+	_, err = w.Write(head[:len(head)-1])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, ",\"moves\":[")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	first := true
+	err = pool.forEachExportMove(client, func(move *PoolExportMove) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(move)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// ExportHistory returns the file with the history of the client. The file
+// is generated while it is uploaded and should be closed after
+func (pool *Pool) ExportHistory(client *PoolClient, format string) (*BufferReader, error) {
+	if !IsExportFormat(format) {
+		return nil, ErrUnknownExportFormat
+	}
+	// the small parts are read before the upload to report the errors early
+	stat, err := pool.getExportStat(client)
+	if err != nil {
+		return nil, err
+	}
+	freqs, err := pool.GetGestureFreqs(client)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("sps_history_%d.%s", client.id.user_id, format)
+	return NewStreamReader(name, client.id.user_id, func(w io.Writer) error {
+		if format == EXPORT_JSON {
+			return pool.writeExportJSON(client, stat, freqs, w)
+		}
+		return pool.writeExportCSV(client, stat, freqs, w)
+	}), nil
+}
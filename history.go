@@ -0,0 +1,224 @@
+/*===============================================================*/
+/* The SPS Bot (game history)                                    */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The history keeps every session as the game with its rounds and moves.
+// The records are not bound to the room so they outlive it. The room name
+// and the gesture signs are saved as they were at the time of the game
+
+var ErrNoGameRecord error = fmt.Errorf("no game record")
+
+// PoolGameRecord is the game in the history of the player
+type PoolGameRecord struct {
+	Id         int64
+	RoomName   string
+	StartedAt  string
+	FinishedAt string
+	Rounds     int
+	Players    int
+	// the result of the player
+	Won        bool
+	Eliminated bool
+}
+
+// PoolRoundRecord is the round of the game in the history of the player
+type PoolRoundRecord struct {
+	Round       int
+	WinnerSigns string
+	Draw        int
+	// the move of the player
+	Sign string
+	Skip bool
+}
+
+// PoolMoveRecord is the move of the member in the round
+type PoolMoveRecord struct {
+	UserName   string
+	Sign       string
+	Skip       bool
+	Eliminated bool
+	Won        bool
+}
+
+// recordGame starts the new game in the history
+func (pool *Pool) recordGame(room *PoolRoom, state *PoolGame) error {
+	id, err := pool.addgame_stmt.DoInsert([]any{
+		room.ownerid.user_id,
+		room.ownerid.chat_id,
+		room.name,
+		state.Session,
+		room.GetRoomSettings().GetMode()})
+	if err != nil {
+		return err
+	}
+	state.GameId = id
+	return nil
+}
+
+func (pool *Pool) recordRound(state *PoolGame) error {
+	if state.GameId == 0 {
+		// the game was started before the history was kept
+		return nil
+	}
+	return pool.addround_stmt.DoUpdate([]any{state.GameId, state.Round})
+}
+
+func (pool *Pool) recordRoundResult(room *PoolRoom, state *PoolGame, winner int64, draw_strategy int64) error {
+	if state.GameId == 0 {
+		return nil
+	}
+	var signs string
+	for _, gesture := range room.GetRules().Gestures {
+		if int64(gesture.Choose)&winner != 0 {
+			signs += gesture.Sign
+		}
+	}
+	return pool.finishround_stmt.DoUpdate([]any{state.GameId, state.Round, winner, signs, draw_strategy})
+}
+
+// recordMove saves the move of the member. The zero choose is the skipped
+// round
+func (pool *Pool) recordMove(room *PoolRoom, state *PoolGame, client *PoolClient, choose int) error {
+	if state.GameId == 0 {
+		return nil
+	}
+	return pool.addmove_stmt.DoUpdate([]any{
+		state.GameId,
+		state.Round,
+		client.id.user_id,
+		client.id.chat_id,
+		client.user_name,
+		choose,
+		room.GetRules().GetSign(choose),
+		choose == 0})
+}
+
+func (pool *Pool) recordEliminated(state *PoolGame, client *PoolClient) error {
+	if state.GameId == 0 {
+		return nil
+	}
+	return pool.elimmove_stmt.DoUpdate([]any{
+		state.GameId,
+		state.Round,
+		client.id.user_id,
+		client.id.chat_id,
+		client.user_name})
+}
+
+// recordGameResultTx finishes the game and marks the winners
+func (pool *Pool) recordGameResultTx(tx *sql.Tx, state *PoolGame, winners []*PoolClient) error {
+	if state.GameId == 0 {
+		return nil
+	}
+	for _, winner_mem := range winners {
+		err := pool.wonmove_stmt.DoUpdateTx(tx, []any{
+			state.GameId,
+			state.Round,
+			winner_mem.id.user_id,
+			winner_mem.id.chat_id,
+			winner_mem.user_name})
+		if err != nil {
+			return err
+		}
+	}
+	return pool.finishgame_stmt.DoUpdateTx(tx, []any{state.GameId})
+}
+
+func gameRecordFromRow(row map[string]any) *PoolGameRecord {
+	return &PoolGameRecord{
+		Id:         row[GAME_ID_COL.name].(int64),
+		RoomName:   row[ROOMNAME_COL.name].(string),
+		StartedAt:  row[STARTED_AT_COL.name].(string),
+		FinishedAt: row[FINISHED_AT_COL.name].(string),
+		Rounds:     int(row[ROUNDS_COL.name].(int64)),
+		Players:    int(row[PLAYERS_COL.name].(int64)),
+		Won:        row[WON_COL.name].(int64) != 0,
+		Eliminated: row[ELIMINATED_COL.name].(int64) != 0,
+	}
+}
+
+var GAME_RECORD_COLS = []variantParam{GAME_ID_COL, ROOMNAME_COL, STARTED_AT_COL, FINISHED_AT_COL,
+	ROUNDS_COL, PLAYERS_COL, WON_COL, ELIMINATED_COL}
+
+// GetHistory returns the games of the client from the most recent one
+func (pool *Pool) GetHistory(client *PoolClient, offset, limit int) ([]*PoolGameRecord, error) {
+	rows, err := pool.gethistory_stmt.DoSelectRows(
+		[]any{client.id.user_id, client.id.chat_id, limit, offset},
+		GAME_RECORD_COLS)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolGameRecord, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, gameRecordFromRow(row))
+	}
+	return result, nil
+}
+
+// GetGameRecord returns the game if the client has played it
+func (pool *Pool) GetGameRecord(client *PoolClient, game_id int64) (*PoolGameRecord, error) {
+	row, err := pool.getgamerecord_stmt.DoSelectRow(
+		[]any{client.id.user_id, client.id.chat_id, game_id},
+		GAME_RECORD_COLS)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoGameRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gameRecordFromRow(row), nil
+}
+
+// GetRoundRecords returns the rounds of the game with the moves of the client
+func (pool *Pool) GetRoundRecords(client *PoolClient, game_id int64) ([]*PoolRoundRecord, error) {
+	rows, err := pool.getroundrecords_stmt.DoSelectRows(
+		[]any{game_id, client.id.user_id, client.id.chat_id},
+		[]variantParam{ROUND_COL, WINNER_SIGNS_COL, DRAW_COL, SIGN_COL, SKIP_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolRoundRecord, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &PoolRoundRecord{
+			Round:       int(row[ROUND_COL.name].(int64)),
+			WinnerSigns: row[WINNER_SIGNS_COL.name].(string),
+			Draw:        int(row[DRAW_COL.name].(int64)),
+			Sign:        row[SIGN_COL.name].(string),
+			Skip:        row[SKIP_COL.name].(int64) != 0,
+		})
+	}
+	return result, nil
+}
+
+// GetMoveRecords returns the moves of all the members in the round
+func (pool *Pool) GetMoveRecords(game_id int64, round int) ([]*PoolMoveRecord, error) {
+	rows, err := pool.getmoverecords_stmt.DoSelectRows(
+		[]any{game_id, round},
+		[]variantParam{USERNAME_COL, SIGN_COL, SKIP_COL, ELIMINATED_COL, WON_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolMoveRecord, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &PoolMoveRecord{
+			UserName:   row[USERNAME_COL.name].(string),
+			Sign:       row[SIGN_COL.name].(string),
+			Skip:       row[SKIP_COL.name].(int64) != 0,
+			Eliminated: row[ELIMINATED_COL.name].(int64) != 0,
+			Won:        row[WON_COL.name].(int64) != 0,
+		})
+	}
+	return result, nil
+}
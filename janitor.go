@@ -0,0 +1,153 @@
+/*===============================================================*/
+/* The SPS Bot (database janitor)                                */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PoolJanitorConfig sets up the background cleanup of the database.
+// Zero or negative values disable the corresponding task
+type PoolJanitorConfig struct {
+	// the minutes between the janitor runs
+	Interval int `json:"interval"`
+	// the hours of inactivity before the started game is closed
+	GameIdle int `json:"game_idle"`
+	// the days of inactivity before the room is deleted
+	RoomIdle int `json:"room_idle"`
+	// the hours between the database vacuums
+	Vacuum int `json:"vacuum"`
+}
+
+func DefaultJanitorConfig() PoolJanitorConfig {
+	return PoolJanitorConfig{
+		Interval: 10,
+		GameIdle: 24,
+		RoomIdle: 90,
+		Vacuum:   24,
+	}
+}
+
+// SetJanitorConfig sets the janitor config. Should be called before
+// GetPoolUpdates starts the janitor
+func (pool *Pool) SetJanitorConfig(cfg PoolJanitorConfig) {
+	pool.janitor_cfg = cfg
+}
+
+func (pool *Pool) janitor() {
+	cfg := pool.janitor_cfg
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	last_vacuum := time.Now()
+	for {
+		time.Sleep(time.Duration(cfg.Interval) * time.Minute)
+
+		if err := pool.cleanUp(cfg); err != nil {
+			log.Printf("Janitor: %v", err)
+		}
+		if err := pool.expireLobby(); err != nil {
+			log.Printf("Janitor: %v", err)
+		}
+		if cfg.Vacuum > 0 && time.Since(last_vacuum) >= time.Duration(cfg.Vacuum)*time.Hour {
+			if _, err := pool.client_db.Exec("vacuum;"); err != nil {
+				log.Printf("Janitor: %v", err)
+			}
+			last_vacuum = time.Now()
+		}
+	}
+}
+
+// getRooms returns the rooms selected with the statement
+func (pool *Pool) getRooms(stmt *StmtWrapper, bindings []any) ([]*PoolRoom, error) {
+	rows, err := stmt.DoSelectRows(
+		bindings,
+		[]variantParam{EUID_COL, ECID_COL, NAME_COL, USERNAME_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolRoom, 0, len(rows))
+	for _, row := range rows {
+		owner := &PoolClient{
+			id: TgUserId{
+				user_id: row[EUID_COL.name].(int64),
+				chat_id: row[ECID_COL.name].(int64)},
+			user_name: row[USERNAME_COL.name].(string)}
+		room, err := pool.GenRoom(owner, row[NAME_COL.name].(string), false, DefaultLocale())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, room)
+	}
+	return result, nil
+}
+
+// getIdleRooms returns the rooms that were not used for the period
+func (pool *Pool) getIdleRooms(period string) ([]*PoolRoom, error) {
+	return pool.getRooms(pool.getidlerooms_stmt, []any{period})
+}
+
+func (pool *Pool) cleanUp(cfg PoolJanitorConfig) error {
+	// the round processing should not interfere with the cleanup
+	pool.choose_mux.Lock()
+	defer pool.choose_mux.Unlock()
+
+	if cfg.RoomIdle > 0 {
+		rooms, err := pool.getIdleRooms(fmt.Sprintf("-%d days", cfg.RoomIdle))
+		if err != nil {
+			return err
+		}
+		for _, room := range rooms {
+			err = pool.deleteRoom(room, UPD_ROOM_IDLE)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the revoked links are useless after the same period
+		err = pool.clrrevoked_stmt.DoUpdate([]any{fmt.Sprintf("-%d days", cfg.RoomIdle)})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.GameIdle > 0 {
+		rooms, err := pool.getIdleRooms(fmt.Sprintf("-%d hours", cfg.GameIdle))
+		if err != nil {
+			return err
+		}
+		for _, room := range rooms {
+			if room.GetGame().State != GST_STARTED {
+				continue
+			}
+			// the players have gone - close the stuck game
+			err = pool.resetRoom(room, UPD_ROOM_IDLE)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the records could be left by the rooms deleted before
+	for _, stmt := range pool.clrorphans_stmts {
+		err := stmt.DoUpdate([]any{})
+		if err != nil {
+			return err
+		}
+	}
+	err := pool.clraiusers_stmt.DoUpdate([]any{})
+	if err != nil {
+		return err
+	}
+
+	return pool.exppassattempts_stmt.DoUpdate([]any{passAttemptsPeriod()})
+}
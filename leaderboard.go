@@ -0,0 +1,90 @@
+/*===============================================================*/
+/* The SPS Bot (leaderboards)                                    */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+)
+
+// the scopes of the leaderboard
+const TOP_SCOPE_GLOBAL = 0
+const TOP_SCOPE_CHAT = 1
+const TOP_SCOPE_ROOM = 2
+
+// the orders of the leaderboard
+const TOP_BY_WINS = 0
+const TOP_BY_WINRATE = 1
+const TOP_BY_RATING = 2
+
+// the minimum games played to be ranked by the win rate
+const TOP_MIN_GAMES = 10
+
+type PoolTopEntry struct {
+	Client *PoolClient
+	Total  int
+	Won    int
+	Rating PlayerRating
+}
+
+func (entry *PoolTopEntry) WinRate() int {
+	if entry.Total == 0 {
+		return 0
+	}
+	return entry.Won * 100 / entry.Total
+}
+
+func (pool *Pool) getTop(stmt *StmtWrapper, order, offset, limit int, bindings ...any) ([]*PoolTopEntry, error) {
+	min_games := 1
+	if order == TOP_BY_WINRATE {
+		min_games = TOP_MIN_GAMES
+	}
+	rows, err := stmt.DoSelectRows(
+		append([]any{order, min_games, limit, offset}, bindings...),
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL,
+			STAT_TOTAL_COL, STAT_WON_COL, RATING_COL, RATING_RD_COL, RATING_VOL_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := make([]*PoolTopEntry, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &PoolTopEntry{
+			Client: &PoolClient{
+				id: TgUserId{
+					row[MUID_COL.name].(int64),
+					row[MCID_COL.name].(int64)},
+				user_name: row[USERNAME_COL.name].(string),
+				locale:    GetLocale(row[LOCALE_COL.name].(string)),
+			},
+			Total: int(row[STAT_TOTAL_COL.name].(int64)),
+			Won:   int(row[STAT_WON_COL.name].(int64)),
+			Rating: PlayerRating{
+				Rating: row[RATING_COL.name].(float64),
+				RD:     row[RATING_RD_COL.name].(float64),
+				Vol:    row[RATING_VOL_COL.name].(float64),
+			},
+		})
+	}
+	return result, nil
+}
+
+// GetGlobalTop returns the page of the leaderboard of all the players
+func (pool *Pool) GetGlobalTop(order, offset, limit int) ([]*PoolTopEntry, error) {
+	return pool.getTop(pool.gettop_stmt, order, offset, limit)
+}
+
+// GetChatTop returns the page of the leaderboard of the chat members
+func (pool *Pool) GetChatTop(chat_id int64, order, offset, limit int) ([]*PoolTopEntry, error) {
+	return pool.getTop(pool.getchattop_stmt, order, offset, limit, chat_id)
+}
+
+// GetRoomTop returns the page of the leaderboard of the players who have
+// ever played in the room
+func (pool *Pool) GetRoomTop(room *PoolRoom, order, offset, limit int) ([]*PoolTopEntry, error) {
+	return pool.getTop(pool.getroomtop_stmt, order, offset, limit,
+		room.ownerid.user_id, room.ownerid.chat_id, room.name)
+}
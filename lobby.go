@@ -0,0 +1,242 @@
+/*===============================================================*/
+/* The SPS Bot (public matchmaking lobby)                        */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// the system identity that owns the matchmaking rooms
+const LOBBY_USER_ID = 0
+const LOBBY_CHAT_ID = 0
+const LOBBY_USER_NAME = "sps_lobby"
+
+// the player counts available in the lobby
+var LOBBY_PLAYERS = []int{2, 3, 4, 6}
+
+// the time the player waits in the lobby before the entry expires
+const LOBBY_TIMEOUT = 15 * time.Minute
+
+// the delay before the finished matchmaking room is deleted. The members
+// get the results of the last round before
+const LOBBY_CLOSE_DELAY = 30 * time.Second
+
+var ErrAlreadyInRoom error = fmt.Errorf("already in the room")
+var ErrNotInLobby error = fmt.Errorf("not in the lobby")
+
+type lobbyEntry struct {
+	client  *PoolClient
+	winrate int
+}
+
+func (pool *Pool) getLobbyOwner() (*PoolClient, error) {
+	id := NewUserId(LOBBY_USER_ID, LOBBY_CHAT_ID)
+	_, err := pool.dbAddCID(id, LOBBY_USER_NAME, DefaultLocale().IETFCode, LOBBY_USER_NAME, "")
+	if err != nil {
+		return nil, err
+	}
+	return pool.NewPoolClient(id, LOBBY_USER_NAME, DefaultLocale())
+}
+
+func (pool *Pool) getWinRate(client *PoolClient) (int, error) {
+	total, won, _, err := pool.GetUserStat(&client.id)
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return won * 100 / total, nil
+}
+
+// GetLobbyPlayers returns the player count the client is waiting for
+// or 0 if the client is not in the lobby
+func (pool *Pool) GetLobbyPlayers(client *PoolClient) (int, error) {
+	cols, err := pool.getlobbyentry_stmt.DoSelectRow(
+		[]any{client.id.user_id, client.id.chat_id},
+		[]variantParam{PLAYERS_COL})
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(cols[PLAYERS_COL.name].(int64)), nil
+}
+
+// FindGame puts the client into the lobby and starts the game as soon as
+// enough players are waiting. Returns the room if the game is found
+func (pool *Pool) FindGame(client *PoolClient, players int) (*PoolRoom, error) {
+	if !slices.Contains(LOBBY_PLAYERS, players) {
+		return nil, ErrNotEnoughPlayers
+	}
+	room, err := pool.GetRoomForClient(client)
+	if err != nil {
+		return nil, err
+	}
+	if room != nil {
+		return nil, ErrAlreadyInRoom
+	}
+	winrate, err := pool.getWinRate(client)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.lobby_mux.Lock()
+	defer pool.lobby_mux.Unlock()
+
+	err = pool.addlobby_stmt.DoUpdate(
+		[]any{client.id.user_id, client.id.chat_id, players, winrate})
+	if err != nil {
+		return nil, err
+	}
+	return pool.matchLobby(players)
+}
+
+func (pool *Pool) CancelFindGame(client *PoolClient) error {
+	players, err := pool.GetLobbyPlayers(client)
+	if err != nil {
+		return err
+	}
+	if players == 0 {
+		return ErrNotInLobby
+	}
+	return pool.rmvlobby_stmt.DoUpdate([]any{client.id.user_id, client.id.chat_id})
+}
+
+// matchLobby creates the room for the waiting players with the closest
+// win rates. Should be called under lobby_mux
+func (pool *Pool) matchLobby(players int) (*PoolRoom, error) {
+	rows, err := pool.getlobby_stmt.DoSelectRows(
+		[]any{players, fmt.Sprintf("-%d seconds", int(LOBBY_TIMEOUT/time.Second))},
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL, WINRATE_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	entries := make([]*lobbyEntry, 0, len(rows))
+	for _, row := range rows {
+		client := &PoolClient{
+			id: TgUserId{
+				row[MUID_COL.name].(int64),
+				row[MCID_COL.name].(int64)},
+			user_name: row[USERNAME_COL.name].(string),
+			locale:    GetLocale(row[LOCALE_COL.name].(string)),
+		}
+		// the player could join some room while waiting
+		room, err := pool.GetRoomForClient(client)
+		if err != nil {
+			return nil, err
+		}
+		if room != nil {
+			err = pool.rmvlobby_stmt.DoUpdate([]any{client.id.user_id, client.id.chat_id})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries = append(entries, &lobbyEntry{client: client, winrate: int(row[WINRATE_COL.name].(int64))})
+	}
+	if len(entries) < players {
+		return nil, nil
+	}
+
+	// the window of the players with the smallest win rate spread. The
+	// stable sort keeps the longest waiting players first
+	slices.SortStableFunc(entries, func(a, b *lobbyEntry) int {
+		return a.winrate - b.winrate
+	})
+	best := 0
+	for i := 1; i+players <= len(entries); i++ {
+		if entries[i+players-1].winrate-entries[i].winrate <
+			entries[best+players-1].winrate-entries[best].winrate {
+			best = i
+		}
+	}
+	entries = entries[best : best+players]
+
+	owner, err := pool.getLobbyOwner()
+	if err != nil {
+		return nil, err
+	}
+	token, err := GenRoomToken()
+	if err != nil {
+		return nil, err
+	}
+	room, err := pool.GenRoom(owner, "lobby_"+token[:8], true, DefaultLocale())
+	if err != nil {
+		return nil, err
+	}
+	setts := *room.GetRoomSettings()
+	setts.MinPlayers = players
+	setts.MaxPlayers = players
+	err = pool.UpdateRoomSettings(room, &setts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		err = pool.rmvlobby_stmt.DoUpdate([]any{entry.client.id.user_id, entry.client.id.chat_id})
+		if err != nil {
+			return nil, err
+		}
+		err = pool.AddMember(room, entry.client)
+		if err != nil {
+			return nil, err
+		}
+		upd := PoolUpdate{
+			Type:   UPD_MATCH_FOUND,
+			Params: []any{entry.client, room}}
+		pool.updates <- upd
+	}
+
+	return room, pool.CloseRoom(room)
+}
+
+// expireLobby removes the players waiting too long from the lobby
+func (pool *Pool) expireLobby() error {
+	pool.lobby_mux.Lock()
+	defer pool.lobby_mux.Unlock()
+
+	rows, err := pool.getlobbyexp_stmt.DoSelectRows(
+		[]any{fmt.Sprintf("-%d seconds", int(LOBBY_TIMEOUT/time.Second))},
+		[]variantParam{MUID_COL, MCID_COL, USERNAME_COL, LOCALE_COL})
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for _, row := range rows {
+		client := &PoolClient{
+			id: TgUserId{
+				row[MUID_COL.name].(int64),
+				row[MCID_COL.name].(int64)},
+			user_name: row[USERNAME_COL.name].(string),
+			locale:    GetLocale(row[LOCALE_COL.name].(string)),
+		}
+		err = pool.rmvlobby_stmt.DoUpdate([]any{client.id.user_id, client.id.chat_id})
+		if err != nil {
+			return err
+		}
+		upd := PoolUpdate{
+			Type:   UPD_LOBBY_EXPIRED,
+			Params: []any{client}}
+		pool.updates <- upd
+	}
+	return nil
+}
+
+// closeLobbyRoom deletes the matchmaking room after the game
+func (pool *Pool) closeLobbyRoom(room *PoolRoom) {
+	time.AfterFunc(LOBBY_CLOSE_DELAY, func() {
+		pool.choose_mux.Lock()
+		defer pool.choose_mux.Unlock()
+
+		pool.deleteRoom(room, UPD_LOBBY_FINISHED)
+	})
+}
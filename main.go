@@ -10,11 +10,13 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -43,6 +45,10 @@ type BotConfig struct {
 	Timeout  int               `json:"timeout"`
 	Debug    bool              `json:"debug"`
 	APIDebug APIBotDebugConfig `json:"api_debug"`
+	// the secret to sign the callback data
+	CallbackSecret string `json:"callback_secret"`
+	// the background cleanup of the database, the defaults are used if not set
+	Janitor *PoolJanitorConfig `json:"janitor"`
 }
 
 const TG_COMMAND_START = "/start"
@@ -53,14 +59,82 @@ const TG_COMMAND_EXITROOM = "/exitroom"
 const TG_COMMAND_CHOOSE = "/choose"
 const TG_COMMAND_STAT = "/stat"
 const TG_COMMAND_RESTARTROOM = "/restart"
+const TG_COMMAND_SETT = "/settings"
+const TG_COMMAND_SETVALUE = "/sett"
+const TG_COMMAND_SETRULES = "/setrules"
+const TG_COMMAND_ADDAI = "/addai"
+const TG_COMMAND_VERIFY = "/verify"
+const TG_COMMAND_QUEUE = "/queue"
+const TG_COMMAND_TRANSFER = "/transfer"
+const TG_COMMAND_KICK = "/kick"
+const TG_COMMAND_BAN = "/ban"
+const TG_COMMAND_UNBAN = "/unban"
+const TG_COMMAND_SETPASS = "/setpass"
+const TG_COMMAND_JOINPASS = "/joinpass"
+const TG_COMMAND_REVOKE = "/revoke"
+const TG_COMMAND_NEWINVITE = "/newinvite"
+const TG_COMMAND_MYROOMS = "/myrooms"
+const TG_COMMAND_RENAME = "/renameroom"
+const TG_COMMAND_FINDGAME = "/findgame"
+const TG_COMMAND_TOP = "/top"
+const TG_COMMAND_HISTORY = "/history"
+const TG_COMMAND_EXPORT = "/export"
+
+const QUEUE_APPROVE = "a"
+const QUEUE_DROP = "d"
+const QUEUE_SHOW = "s"
+
+const MYROOMS_SHOW = "s"
+const MYROOMS_ENTER = "e"
+const MYROOMS_INVITE = "i"
+const MYROOMS_RENAME = "r"
+const MYROOMS_DELETE = "d"
+const MYROOMS_CONFIRM = "x"
+
+// the maximum number of rooms in the /myrooms list
+const MAX_MY_ROOMS = 20
+
+// the number of players on the page of the leaderboard
+const TOP_PAGE_SIZE = 10
+
+const HISTORY_LIST = "l"
+const HISTORY_GAME = "g"
+const HISTORY_ROUND = "r"
+
+// the number of games on the page of the history
+const HISTORY_PAGE_SIZE = 10
+
+// the maximum number of rounds shown for the game
+const HISTORY_MAX_ROUNDS = 50
+
+const SETT_RULES = "rules"
+const SETT_TIMEOUT = "timeout"
+const SETT_POLICY = "policy"
+const SETT_MATCH = "match"
+const SETT_DRAW = "draw"
+const SETT_DRAW_LIMIT = "drawlim"
+const SETT_MODE = "mode"
+const SETT_ROUNDS = "rounds"
+const SETT_LIVES = "lives"
+const SETT_COMMIT = "commit"
+const SETT_OWNER = "owner"
+const SETT_MIN = "min"
+const SETT_MAX = "max"
+const SETT_AUTOSTART = "autostart"
+const SETT_INVITE_TTL = "ttl"
+const SETT_INVITE_USES = "uses"
 
 const CHOOSE_STONE = 1
 const CHOOSE_SCISSORS = 2
 const CHOOSE_PAPER = 4
+const CHOOSE_LIZARD = 8
+const CHOOSE_SPOCK = 16
 
 const SIGN_STONE = "\U0000270A"
 const SIGN_SCISSORS = "\U0000270C"
 const SIGN_PAPER = "\U0000270B"
+const SIGN_LIZARD = "\U0001F98E"
+const SIGN_SPOCK = "\U0001F596"
 
 func ErrorToString(err error) string {
 	return fmt.Sprintf("%v", err)
@@ -68,6 +142,91 @@ func ErrorToString(err error) string {
 
 /* Global Commands */
 
+func TimeoutPolicyToStr(policy int, locale *LanguageStrings) string {
+	switch policy {
+	case TIMEOUT_RANDOM:
+		return locale.TimeoutRandom
+	case TIMEOUT_SKIP:
+		return locale.TimeoutSkip
+	default:
+		return locale.TimeoutEliminate
+	}
+}
+
+func TurnTimeoutToStr(timeout int, locale *LanguageStrings) string {
+	if timeout <= 0 {
+		return locale.TimeoutNone
+	}
+	return fmt.Sprintf(locale.TimeoutSeconds, timeout)
+}
+
+func InviteTTLToStr(hours int, locale *LanguageStrings) string {
+	if hours <= 0 {
+		return locale.TimeoutNone
+	}
+	if hours%24 == 0 {
+		return fmt.Sprintf(locale.TTLDays, hours/24)
+	}
+	return fmt.Sprintf(locale.TTLHours, hours)
+}
+
+// NextOption returns the option following the value in the cycle
+func NextOption(options []int, value int) int {
+	if i := slices.Index(options, value); i >= 0 {
+		return options[(i+1)%len(options)]
+	}
+	return options[0]
+}
+
+func MatchWinsToStr(wins int, locale *LanguageStrings) string {
+	if wins <= 1 {
+		return locale.MatchSingle
+	}
+	return fmt.Sprintf(locale.MatchFirstTo, wins)
+}
+
+func OnOffToStr(value bool, locale *LanguageStrings) string {
+	if value {
+		return locale.ValueOn
+	}
+	return locale.ValueOff
+}
+
+func OwnerLeaveToStr(value int, locale *LanguageStrings) string {
+	if value == OWNER_LEAVE_TRANSFER {
+		return locale.OwnerLeaveTransfer
+	}
+	return locale.OwnerLeaveClose
+}
+
+func MaxPlayersToStr(value int, locale *LanguageStrings) string {
+	if value <= 0 {
+		return locale.PlayersUnlimited
+	}
+	return strconv.Itoa(value)
+}
+
+func RoomErrorToString(err error, room *PoolRoom, locale *LanguageStrings) string {
+	switch err {
+	case ErrAlreadyClosed:
+		return locale.RoomAlreadyClosed
+	case ErrNoActiveRooms:
+		return locale.NoActiveRooms
+	case ErrNotEnoughPlayers:
+		return fmt.Sprintf(locale.NotEnoughPlayers, room.GetRoomSettings().GetMinPlayers())
+	}
+	return ErrorToString(err)
+}
+
+func ModeToStr(mode int, locale *LanguageStrings) string {
+	switch mode {
+	case MODE_SCORING:
+		return locale.ModeScoring
+	default:
+		return locale.ModeElimination
+	}
+}
+
 func PSTToStr(st int, locale *LanguageStrings) string {
 	switch st {
 	case PST_PLAYING:
@@ -154,6 +313,12 @@ func PrepareInitCommands(tid TgUserId, locale *LanguageStrings) tgbotapi.Chattab
 		tgbotapi.BotCommand{Command: TG_COMMAND_START, Description: locale.CommandStart},
 		tgbotapi.BotCommand{Command: TG_COMMAND_STAT, Description: locale.CommandGetStat},
 		tgbotapi.BotCommand{Command: TG_COMMAND_NEWROOM, Description: locale.CommandNewRoom},
+		tgbotapi.BotCommand{Command: TG_COMMAND_SETT, Description: locale.CommandSett},
+		tgbotapi.BotCommand{Command: TG_COMMAND_MYROOMS, Description: locale.CommandMyRooms},
+		tgbotapi.BotCommand{Command: TG_COMMAND_FINDGAME, Description: locale.CommandFindGame},
+		tgbotapi.BotCommand{Command: TG_COMMAND_TOP, Description: locale.CommandTop},
+		tgbotapi.BotCommand{Command: TG_COMMAND_HISTORY, Description: locale.CommandHistory},
+		tgbotapi.BotCommand{Command: TG_COMMAND_EXPORT, Description: locale.CommandExport},
 		tgbotapi.BotCommand{Command: TG_COMMAND_EXITROOM, Description: locale.CommandExitRoom},
 	)
 	if tid.GetChatID() != 0 {
@@ -198,6 +363,156 @@ func PrepareToAuthorize(actor *PoolActor) tgbotapi.MessageConfig {
 	return msg
 }
 
+func PrepareRoomSettings(room *PoolRoom, hash string, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	setts := room.GetRoomSettings()
+
+	next_rules := setts.Rules + 1
+	if next_rules > RULES_CUSTOM || (next_rules == RULES_CUSTOM && setts.Custom == nil) {
+		next_rules = RULES_CLASSIC
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(locale.RoomSettings, html.EscapeString(room.GetName())))
+	if setts.Rules == RULES_CUSTOM {
+		rules := room.GetRules()
+		b.WriteByte(0xA)
+		for _, gesture := range rules.Gestures {
+			b.WriteByte(0xA)
+			b.WriteString(html.EscapeString(rules.GetLabel(gesture.Choose)))
+			b.WriteString(" \U000027A1 ")
+			for _, other := range rules.Gestures {
+				if gesture.Beats&other.Choose != 0 {
+					b.WriteString(html.EscapeString(other.Sign))
+				}
+			}
+		}
+	}
+
+	sett_button := func(caption string, key string, value int) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(caption,
+			fmt.Sprintf("%s&%s&%d&%s", TG_COMMAND_SETVALUE, key, value, hash))
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettRules, RulesToStr(setts.Rules, locale)),
+				SETT_RULES, next_rules),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.SettCustomRules,
+				fmt.Sprintf("%s&%s", TG_COMMAND_SETRULES, hash)),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettTimeout, TurnTimeoutToStr(setts.TurnTimeout, locale)),
+				SETT_TIMEOUT, NextOption(TURN_TIMEOUTS, setts.TurnTimeout)),
+			sett_button(fmt.Sprintf(locale.SettPolicy, TimeoutPolicyToStr(setts.TimeoutPolicy, locale)),
+				SETT_POLICY, (setts.TimeoutPolicy+1)%TIMEOUT_POLICY_CNT),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettMatch, MatchWinsToStr(setts.GetMatchWins(), locale)),
+				SETT_MATCH, NextOption(MATCH_WINS, setts.GetMatchWins())),
+		})
+
+	mode_row := []tgbotapi.InlineKeyboardButton{
+		sett_button(fmt.Sprintf(locale.SettMode, ModeToStr(setts.GetMode(), locale)),
+			SETT_MODE, (setts.GetMode()+1)%MODE_CNT),
+	}
+	if setts.GetMode() == MODE_SCORING {
+		mode_row = append(mode_row,
+			sett_button(fmt.Sprintf(locale.SettRounds, setts.GetRounds()),
+				SETT_ROUNDS, NextOption(SCORING_ROUNDS, setts.GetRounds())))
+	} else {
+		mode_row = append(mode_row,
+			sett_button(fmt.Sprintf(locale.SettLives, setts.GetLives()),
+				SETT_LIVES, NextOption(PLAYER_LIVES, setts.GetLives())))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, mode_row)
+
+	if setts.GetMode() == MODE_ELIMINATION {
+		draw_row := []tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettDraw, DrawStrategyToStr(setts.DrawStrategy, locale)),
+				SETT_DRAW, (setts.DrawStrategy+1)%DRAW_STRATEGY_CNT),
+		}
+		if setts.DrawStrategy == DRAW_SUDDEN_DEATH {
+			draw_row = append(draw_row,
+				sett_button(fmt.Sprintf(locale.SettDrawLimit, setts.GetDrawLimit()),
+					SETT_DRAW_LIMIT, NextOption(DRAW_LIMITS, setts.GetDrawLimit())))
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, draw_row)
+	}
+
+	commit_reveal := 0
+	if setts.CommitReveal {
+		commit_reveal = 1
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettCommitReveal, OnOffToStr(setts.CommitReveal, locale)),
+				SETT_COMMIT, 1-commit_reveal),
+			sett_button(fmt.Sprintf(locale.SettOwnerLeave, OwnerLeaveToStr(setts.OwnerLeave, locale)),
+				SETT_OWNER, 1-setts.GetOwnerLeave()),
+		})
+
+	auto_start := 0
+	if setts.AutoStart {
+		auto_start = 1
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettMinPlayers, setts.GetMinPlayers()),
+				SETT_MIN, NextOption(MIN_PLAYERS, setts.GetMinPlayers())),
+			sett_button(fmt.Sprintf(locale.SettMaxPlayers, MaxPlayersToStr(setts.GetMaxPlayers(), locale)),
+				SETT_MAX, NextOption(MAX_PLAYERS, setts.GetMaxPlayers())),
+			sett_button(fmt.Sprintf(locale.SettAutoStart, OnOffToStr(setts.AutoStart, locale)),
+				SETT_AUTOSTART, 1-auto_start),
+		})
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		[]tgbotapi.InlineKeyboardButton{
+			sett_button(fmt.Sprintf(locale.SettInviteTTL, InviteTTLToStr(setts.GetInviteTTL(), locale)),
+				SETT_INVITE_TTL, NextOption(INVITE_TTLS, setts.GetInviteTTL())),
+			sett_button(fmt.Sprintf(locale.SettInviteUses, MaxPlayersToStr(setts.GetInviteUses(), locale)),
+				SETT_INVITE_USES, NextOption(INVITE_USES, setts.GetInviteUses())),
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.SettNewInvite,
+				fmt.Sprintf("%s&%s", TG_COMMAND_NEWINVITE, hash)),
+		})
+
+	pass_row := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf(locale.SettPassword, OnOffToStr(setts.HasPassword(), locale)),
+			fmt.Sprintf("%s&%s", TG_COMMAND_SETPASS, hash)),
+	}
+	if setts.HasPassword() {
+		pass_row = append(pass_row,
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.SettNoPassword,
+				fmt.Sprintf("%s&0&%s", TG_COMMAND_SETPASS, hash)))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, pass_row)
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.SettKick,
+				fmt.Sprintf("%s&%s", TG_COMMAND_KICK, hash)),
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.SettBans,
+				fmt.Sprintf("%s&%s", TG_COMMAND_BAN, hash)),
+		})
+
+	ai_row := make([]tgbotapi.InlineKeyboardButton, 0, AI_STRATEGY_CNT)
+	for strategy := AI_RANDOM; strategy < AI_STRATEGY_CNT; strategy++ {
+		ai_row = append(ai_row, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf(locale.SettAddAI, AIStrategyToStr(strategy, locale)),
+			fmt.Sprintf("%s&%d&%s", TG_COMMAND_ADDAI, strategy, hash)))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, ai_row)
+
+	return b.String(), keyboard
+}
+
 /* Bot handler */
 
 type BotHandler struct {
@@ -227,10 +542,23 @@ func (handler *BotHandler) GetLocale() *LanguageStrings {
 
 func (handler *BotHandler) Send(msg tgbotapi.Chattable) {
 	if handler.Bot != nil {
-		handler.Bot.Send(msg)
+		SendSigned(handler.Bot, msg)
 	}
 }
 
+// Detach runs the slow action out of the update loop with the copy of the
+// handler. The copy reports its error by itself
+func (handler *BotHandler) Detach(action func(*BotHandler)) {
+	detached := *handler
+	go func() {
+		action(&detached)
+		if len(detached.ErrorStr) > 0 && detached.GetChatID() > 0 {
+			handler.Bot.Send(PrepareDoLog(detached.GetChatID(),
+				fmt.Sprintf(DefaultLocale().ErrorDetected, detached.ErrorStr)))
+		}
+	}()
+}
+
 func (handler *BotHandler) GetUserName() string {
 	if handler.Actor != nil {
 		return handler.Actor.GetUserName()
@@ -262,6 +590,46 @@ func (handler *BotHandler) GetParamAsInt64(id int) (int64, error) {
 	return i, nil
 }
 
+// the mark of the negative id in the params. ParseCommand replaces the
+// minus sign so the group chat ids are marked with the letter out of
+// the base 36 digits
+const PARAM_NEGATIVE = "N"
+
+func formatIdParam(id int64) string {
+	if id < 0 {
+		return PARAM_NEGATIVE + strconv.FormatUint(uint64(-id), 36)
+	}
+	return strconv.FormatInt(id, 36)
+}
+
+func parseIdParam(param string) (int64, error) {
+	if abs, ok := strings.CutPrefix(param, PARAM_NEGATIVE); ok {
+		id, err := strconv.ParseInt(abs, 36, 64)
+		return -id, err
+	}
+	return strconv.ParseInt(param, 36, 64)
+}
+
+// FormatUserIdParam packs the user and the chat ids into two params of
+// the callback data. The base 36 keeps the group chat ids within the
+// 64 bytes of the callback data
+func FormatUserIdParam(id *TgUserId) string {
+	return formatIdParam(id.GetUserID()) + "&" + formatIdParam(id.GetChatID())
+}
+
+// GetParamAsUserId parses the params packed with FormatUserIdParam
+func (handler *BotHandler) GetParamAsUserId(id int) (TgUserId, error) {
+	uid, err := parseIdParam(handler.Params[id])
+	if err != nil {
+		return TgUserId{}, err
+	}
+	cid, err := parseIdParam(handler.Params[id+1])
+	if err != nil {
+		return TgUserId{}, err
+	}
+	return NewUserId(uid, cid), nil
+}
+
 func (handler *BotHandler) HandleStart() {
 	if handler.Actor.IsAuthorized() {
 		handler.Send(PrepareAuthorized(handler.Actor))
@@ -300,6 +668,37 @@ func (handler *BotHandler) HandleJoinRoom() {
 	}
 
 	_, err := handler.Actor.GetPool().AuthorizeWithHash(handler.Actor.GetClient(), handler.Params[0])
+	if err == ErrPasswordRequired {
+		// ask for the password before join
+		msg := tgbotapi.NewMessage(handler.GetChatID(),
+			fmt.Sprintf(handler.GetLocale().EnterPassword,
+				TG_COMMAND_JOINPASS, handler.Params[0]))
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = tgbotapi.ForceReply{
+			ForceReply:            true,
+			InputFieldPlaceholder: "password",
+		}
+		handler.Send(msg)
+		return
+	}
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+}
+
+func (handler *BotHandler) HandleJoinPasswordInput(pass string, msg_id int) {
+	// room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	// do not keep the password in the chat history
+	handler.Send(tgbotapi.NewDeleteMessage(handler.GetChatID(), msg_id))
+
+	_, err := handler.Actor.GetPool().AuthorizeWithPassword(handler.Actor.GetClient(),
+		handler.Params[0], strings.TrimSpace(pass))
 	if err != nil {
 		handler.ErrorStr = ErrorToString(err)
 		return
@@ -307,7 +706,12 @@ func (handler *BotHandler) HandleJoinRoom() {
 }
 
 func (handler *BotHandler) HandleGetStat() {
-	t, w, err := handler.Actor.GetPool().GetUserStat(handler.Actor.GetID())
+	t, w, m, err := handler.Actor.GetPool().GetUserStat(handler.Actor.GetID())
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	rating, err := handler.Actor.GetPool().GetUserRating(handler.Actor.GetID())
 	if err != nil {
 		handler.ErrorStr = ErrorToString(err)
 		return
@@ -316,91 +720,1557 @@ func (handler *BotHandler) HandleGetStat() {
 		fmt.Sprintf(handler.GetLocale().UserStat,
 			handler.Bot.Self.UserName,
 			handler.Actor.GetUserName(),
-			w, t-w))
+			w, t-w, m, rating.Rating, rating.RD))
 	msg.ParseMode = PM_HTML
 	handler.Send(msg)
 }
 
-func (handler *BotHandler) HandleNewRoomInput(new_name string) {
-	if handler.Actor.IsAuthorized() {
-		handler.Send(PrepareAuthorized(handler.Actor))
-	} else {
-		// if not authorized
-		room, err := handler.Actor.GetPool().GenRoom(handler.Actor.GetClient(), new_name, true, handler.GetLocale())
-		if err != nil {
-			handler.ErrorStr = ErrorToString(err)
+func (handler *BotHandler) SendRoomSettings(room *PoolRoom) {
+	hash, err := handler.Actor.GetPool().GetHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	txt, keyboard := PrepareRoomSettings(room, hash, handler.GetLocale())
+	msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = keyboard
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleRoomSettings() {
+	room := handler.Actor.GetRoom()
+	if room == nil {
+		handler.ErrorStr = handler.GetLocale().NoRoomDetected
+		return
+	}
+	if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+		handler.ErrorStr = handler.GetLocale().NotRoomOwner
+		return
+	}
+
+	handler.SendRoomSettings(room)
+}
+
+func (handler *BotHandler) HandleSetRoomSetting(msg_id int) {
+	// sett_key, sett_value, room_hash
+	if handler.GetParamCnt() < 3 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[2])
+	if room == nil {
+		return
+	}
+
+	value, err := handler.GetParamAsInt64(1)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	setts := *room.GetRoomSettings()
+	switch handler.Params[0] {
+	case SETT_RULES:
+		if value < 0 || value > RULES_CUSTOM || (value == RULES_CUSTOM && setts.Custom == nil) {
+			handler.ErrorStr = handler.GetLocale().NoParams
 			return
 		}
-		err = handler.Actor.GetPool().ExitRoom(room, handler.Actor.GetClient())
-		if err != nil {
-			handler.ErrorStr = ErrorToString(err)
+		setts.Rules = int(value)
+	case SETT_TIMEOUT:
+		if !slices.Contains(TURN_TIMEOUTS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
 			return
 		}
-
-		msg := tgbotapi.NewMessage(handler.GetChatID(),
-			fmt.Sprintf(handler.GetLocale().RoomCreated,
-				room.GetName()))
-		msg.ParseMode = PM_HTML
-		handler.Send(msg)
-
-		token, err := handler.Actor.GetPool().GetHashForRoom(room)
-		if err != nil {
-			handler.ErrorStr = ErrorToString(err)
+		setts.TurnTimeout = int(value)
+	case SETT_POLICY:
+		if value < 0 || value >= TIMEOUT_POLICY_CNT {
+			handler.ErrorStr = handler.GetLocale().NoParams
 			return
 		}
+		setts.TimeoutPolicy = int(value)
+	case SETT_MATCH:
+		if !slices.Contains(MATCH_WINS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.MatchWins = int(value)
+	case SETT_DRAW:
+		if value < 0 || value >= DRAW_STRATEGY_CNT {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.DrawStrategy = int(value)
+	case SETT_DRAW_LIMIT:
+		if !slices.Contains(DRAW_LIMITS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.DrawLimit = int(value)
+	case SETT_MODE:
+		if value < 0 || value >= MODE_CNT {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.Mode = int(value)
+	case SETT_ROUNDS:
+		if !slices.Contains(SCORING_ROUNDS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.Rounds = int(value)
+	case SETT_LIVES:
+		if !slices.Contains(PLAYER_LIVES, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.Lives = int(value)
+	case SETT_COMMIT:
+		setts.CommitReveal = value != 0
+	case SETT_OWNER:
+		setts.OwnerLeave = int(value) % 2
+	case SETT_MIN:
+		if !slices.Contains(MIN_PLAYERS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.MinPlayers = int(value)
+		if setts.GetMaxPlayers() > 0 && setts.GetMaxPlayers() < setts.MinPlayers {
+			setts.MaxPlayers = setts.MinPlayers
+		}
+	case SETT_MAX:
+		if !slices.Contains(MAX_PLAYERS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.MaxPlayers = int(value)
+		if setts.MaxPlayers > 0 && setts.MaxPlayers < setts.GetMinPlayers() {
+			setts.MinPlayers = setts.MaxPlayers
+		}
+	case SETT_AUTOSTART:
+		setts.AutoStart = value != 0
+	case SETT_INVITE_TTL:
+		if !slices.Contains(INVITE_TTLS, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.InviteTTL = int(value)
+	case SETT_INVITE_USES:
+		if !slices.Contains(INVITE_USES, int(value)) {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		setts.InviteUses = int(value)
+	default:
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
 
-		// gen message to send join invitation
-		msg = tgbotapi.NewMessage(handler.GetChatID(),
-			fmt.Sprintf(handler.GetLocale().JoinRoomInvite,
-				handler.Bot.Self.UserName, TG_COMMAND_JOINROOM[1:], token, room.GetName()))
-		msg.ParseMode = PM_HTML
-
-		handler.Send(msg)
+	err = handler.Actor.GetPool().UpdateRoomSettings(room, &setts)
+	if err != nil {
+		if err == ErrAlreadyClosed {
+			handler.ErrorStr = handler.GetLocale().RoomAlreadyClosed
+		} else {
+			handler.ErrorStr = ErrorToString(err)
+		}
+		return
 	}
+
+	txt, keyboard := PrepareRoomSettings(room, handler.Params[2], handler.GetLocale())
+	msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
 }
 
-func main() {
-	cfgFile, err := os.Open("config.json")
-	check(err)
-	byteValue, err := io.ReadAll(cfgFile)
-	check(err)
-	var bot_cfg BotConfig
-	err = json.Unmarshal(byteValue, &bot_cfg)
-	check(err)
-	cfgFile.Close()
+func PrepareJoinQueue(room *PoolRoom, pending []*PoolClient, hash string, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(locale.JoinQueue, html.EscapeString(room.GetName())))
+	b.WriteByte(0xA)
+	if len(pending) == 0 {
+		b.WriteString(locale.JoinQueueEmpty)
+	}
 
-	clientpool, err := NewPool(bot_cfg.Database)
-	check(err)
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(pending)+1)
+	for i, client := range pending {
+		b.WriteString(fmt.Sprintf("%d. @%s\n", i+1, client.GetUserName()))
+		rows = append(rows,
+			[]tgbotapi.InlineKeyboardButton{
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf(locale.QueueApprove, client.GetUserName()),
+					fmt.Sprintf("%s&%s&%s&%s", TG_COMMAND_QUEUE, QUEUE_APPROVE, FormatUserIdParam(client.GetID()), hash)),
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf(locale.QueueDrop, client.GetUserName()),
+					fmt.Sprintf("%s&%s&%s&%s", TG_COMMAND_QUEUE, QUEUE_DROP, FormatUserIdParam(client.GetID()), hash)),
+			})
+	}
+	rows = append(rows,
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(
+				locale.QueueRefresh,
+				fmt.Sprintf("%s&%s", TG_COMMAND_QUEUE, hash)),
+		})
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
 
-	var bot *tgbotapi.BotAPI
-	// debug cases only
-	if bot_cfg.APIDebug.Enabled {
-		tr := http.DefaultTransport.(*http.Transport).Clone()
+func (handler *BotHandler) SendJoinQueue(room *PoolRoom, msg_id int) {
+	hash, err := handler.Actor.GetPool().GetHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	pending, err := handler.Actor.GetPool().GetPendingMembers(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
 
-		if bot_cfg.APIDebug.InsecureSkipVerify {
-			tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		}
-		client := &http.Client{Transport: tr}
-		bot, err = tgbotapi.NewBotAPIWithClient(bot_cfg.APIDebug.Token, bot_cfg.APIDebug.Endpoint, client)
+	txt, keyboard := PrepareJoinQueue(room, pending, hash, handler.GetLocale())
+	if msg_id != 0 {
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
 	} else {
-		bot, err = tgbotapi.NewBotAPI(bot_cfg.BotToken)
+		msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = keyboard
+		handler.Send(msg)
 	}
-	check(err)
+}
 
-	bot.Debug = bot_cfg.Debug
+func (handler *BotHandler) HandleJoinQueue() {
+	room := handler.Actor.GetRoom()
+	if room == nil {
+		handler.ErrorStr = handler.GetLocale().NoRoomDetected
+		return
+	}
+	if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+		handler.ErrorStr = handler.GetLocale().NotRoomOwner
+		return
+	}
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	handler.SendJoinQueue(room, 0)
+}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = bot_cfg.Timeout
+func (handler *BotHandler) HandleJoinQueueAction(msg_id int) {
+	// [action, [user_id, chat_id,]] room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[handler.GetParamCnt()-1])
+	if room == nil {
+		return
+	}
+
+	if handler.GetParamCnt() > 1 {
+		var err error = nil
+		switch handler.Params[0] {
+		case QUEUE_APPROVE, QUEUE_DROP:
+			if handler.GetParamCnt() < 4 {
+				handler.ErrorStr = handler.GetLocale().NoParams
+				return
+			}
+			var id TgUserId
+			id, err = handler.GetParamAsUserId(1)
+			if err != nil {
+				handler.ErrorStr = ErrorToString(err)
+				return
+			}
+			if handler.Params[0] == QUEUE_APPROVE {
+				err = handler.Actor.GetPool().ApprovePending(room, &id)
+			} else {
+				err = handler.Actor.GetPool().DropPending(room, &id)
+			}
+		case QUEUE_SHOW:
+			// show the queue in the new message
+			msg_id = 0
+		default:
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		// the entry could be already handled - just refresh the queue
+		if err != nil && err != ErrNotInQueue {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+	}
+
+	handler.SendJoinQueue(room, msg_id)
+}
+
+func RoomStateToStr(state int, locale *LanguageStrings) string {
+	switch state {
+	case GST_STARTED:
+		return locale.RoomStateStarted
+	case GST_ROOM_CLOSED_WAIT_TO_START:
+		return locale.RoomStateFinished
+	default:
+		return locale.RoomStateWaiting
+	}
+}
+
+func PrepareMyRooms(rooms []*PoolRoomInfo, hashes []string, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(locale.MyRooms)
+	b.WriteByte(0xA)
+	if len(rooms) == 0 {
+		b.WriteString(locale.MyRoomsEmpty)
+	}
+
+	myrooms_button := func(caption string, action string, hash string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(caption,
+			fmt.Sprintf("%s&%s&%s", TG_COMMAND_MYROOMS, action, hash))
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(rooms)+1)
+	for i, info := range rooms {
+		b.WriteString(fmt.Sprintf(locale.MyRoomsItem, i+1,
+			html.EscapeString(info.Room.GetName()),
+			RoomStateToStr(info.Room.GetGame().State, locale),
+			info.Members, info.LastUsed))
+		b.WriteByte(0xA)
+		rows = append(rows,
+			[]tgbotapi.InlineKeyboardButton{
+				myrooms_button(fmt.Sprintf(locale.MyRoomsEnter, i+1), MYROOMS_ENTER, hashes[i]),
+				myrooms_button(locale.MyRoomsInvite, MYROOMS_INVITE, hashes[i]),
+				myrooms_button(locale.MyRoomsRename, MYROOMS_RENAME, hashes[i]),
+				myrooms_button(locale.MyRoomsDelete, MYROOMS_DELETE, hashes[i]),
+			})
+	}
+	rows = append(rows,
+		[]tgbotapi.InlineKeyboardButton{
+			myrooms_button(locale.QueueRefresh, MYROOMS_SHOW, "0"),
+		})
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (handler *BotHandler) SendMyRooms(msg_id int) {
+	rooms, err := handler.Actor.GetPool().GetOwnedRooms(handler.Actor.GetClient())
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	if len(rooms) > MAX_MY_ROOMS {
+		rooms = rooms[:MAX_MY_ROOMS]
+	}
+	hashes := make([]string, len(rooms))
+	for i, info := range rooms {
+		hashes[i], err = handler.Actor.GetPool().GetHashForRoom(info.Room)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+	}
+
+	txt, keyboard := PrepareMyRooms(rooms, hashes, handler.GetLocale())
+	if msg_id != 0 {
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = keyboard
+		handler.Send(msg)
+	}
+}
+
+func (handler *BotHandler) HandleMyRooms() {
+	handler.SendMyRooms(0)
+}
+
+func (handler *BotHandler) HandleMyRoomsAction(msg_id int) {
+	// action, room_hash
+	if handler.GetParamCnt() < 2 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	if handler.Params[0] == MYROOMS_SHOW {
+		handler.SendMyRooms(msg_id)
+		return
+	}
+
+	hash := handler.Params[1]
+	room := handler.getOwnedRoomWithHash(hash)
+	if room == nil {
+		return
+	}
+
+	switch handler.Params[0] {
+	case MYROOMS_ENTER:
+		if handler.Actor.IsAuthorized() {
+			handler.Send(PrepareAuthorized(handler.Actor))
+			return
+		}
+		_, err := handler.Actor.GetPool().AuthorizeWithHash(handler.Actor.GetClient(), hash)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		handler.SendRoomSettings(room)
+	case MYROOMS_INVITE:
+		handler.SendInvites(room)
+	case MYROOMS_RENAME:
+		msg := tgbotapi.NewMessage(handler.GetChatID(),
+			fmt.Sprintf(handler.GetLocale().SetRoomName,
+				TG_COMMAND_RENAME, hash, html.EscapeString(room.GetName())))
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = tgbotapi.ForceReply{
+			ForceReply:            true,
+			InputFieldPlaceholder: "room",
+		}
+		handler.Send(msg)
+	case MYROOMS_DELETE:
+		// ask for the confirmation
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id,
+			fmt.Sprintf(handler.GetLocale().DeleteRoomConfirm, html.EscapeString(room.GetName())),
+			tgbotapi.NewInlineKeyboardMarkup(
+				[]tgbotapi.InlineKeyboardButton{
+					tgbotapi.NewInlineKeyboardButtonData(
+						handler.GetLocale().MyRoomsDelete,
+						fmt.Sprintf("%s&%s&%s", TG_COMMAND_MYROOMS, MYROOMS_CONFIRM, hash)),
+					tgbotapi.NewInlineKeyboardButtonData(
+						handler.GetLocale().Cancel,
+						fmt.Sprintf("%s&%s&0", TG_COMMAND_MYROOMS, MYROOMS_SHOW)),
+				}))
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+	case MYROOMS_CONFIRM:
+		err := handler.Actor.GetPool().DeleteRoom(room)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		handler.SendMyRooms(msg_id)
+	default:
+		handler.ErrorStr = handler.GetLocale().NoParams
+	}
+}
+
+func (handler *BotHandler) HandleRenameRoomInput(new_name string) {
+	// room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[0])
+	if room == nil {
+		return
+	}
+
+	new_name = strings.TrimSpace(new_name)
+	if len(new_name) == 0 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	room, err := handler.Actor.GetPool().RenameRoom(room, new_name)
+	if err != nil {
+		switch err {
+		case ErrGameInProgress:
+			handler.ErrorStr = handler.GetLocale().RoomInProgress
+		case ErrRoomExists:
+			handler.ErrorStr = handler.GetLocale().RoomExists
+		default:
+			handler.ErrorStr = ErrorToString(err)
+		}
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().RoomRenamed, html.EscapeString(room.GetName())))
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+}
+
+func PrepareLobbyWaiting(players int, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	return fmt.Sprintf(locale.LobbyWaiting, players),
+		tgbotapi.NewInlineKeyboardMarkup(
+			[]tgbotapi.InlineKeyboardButton{
+				tgbotapi.NewInlineKeyboardButtonData(
+					locale.Cancel,
+					fmt.Sprintf("%s&0", TG_COMMAND_FINDGAME)),
+			})
+}
+
+func (handler *BotHandler) HandleFindGame() {
+	if handler.Actor.IsAuthorized() {
+		handler.Send(PrepareAuthorized(handler.Actor))
+		return
+	}
+
+	players, err := handler.Actor.GetPool().GetLobbyPlayers(handler.Actor.GetClient())
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	if players > 0 {
+		txt, keyboard := PrepareLobbyWaiting(players, handler.GetLocale())
+		msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = keyboard
+		handler.Send(msg)
+		return
+	}
+
+	row := make([]tgbotapi.InlineKeyboardButton, 0, len(LOBBY_PLAYERS))
+	for _, players := range LOBBY_PLAYERS {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			strconv.Itoa(players),
+			fmt.Sprintf("%s&%d", TG_COMMAND_FINDGAME, players)))
+	}
+	msg := tgbotapi.NewMessage(handler.GetChatID(), handler.GetLocale().LobbyChoosePlayers)
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleFindGameAction(msg_id int) {
+	// players
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	players, err := handler.GetParamAsInt64(0)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	if players == 0 {
+		err = handler.Actor.GetPool().CancelFindGame(handler.Actor.GetClient())
+		if err != nil && err != ErrNotInLobby {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		msg := tgbotapi.NewEditMessageText(handler.GetChatID(), msg_id, handler.GetLocale().LobbyCancelled)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+		return
+	}
+
+	room, err := handler.Actor.GetPool().FindGame(handler.Actor.GetClient(), int(players))
+	if err == ErrAlreadyInRoom {
+		handler.Send(PrepareAuthorized(handler.Actor))
+		return
+	}
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	if room != nil {
+		// the players are notified by the pool
+		handler.Send(tgbotapi.NewDeleteMessage(handler.GetChatID(), msg_id))
+		return
+	}
+
+	txt, keyboard := PrepareLobbyWaiting(int(players), handler.GetLocale())
+	msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+}
+
+func TopScopeToStr(scope int, locale *LanguageStrings) string {
+	switch scope {
+	case TOP_SCOPE_CHAT:
+		return locale.TopScopeChat
+	case TOP_SCOPE_ROOM:
+		return locale.TopScopeRoom
+	}
+	return locale.TopScopeGlobal
+}
+
+func TopOrderToStr(order int, locale *LanguageStrings) string {
+	switch order {
+	case TOP_BY_WINRATE:
+		return locale.TopByWinRate
+	case TOP_BY_RATING:
+		return locale.TopByRating
+	}
+	return locale.TopByWins
+}
+
+// PrepareTop prepares the page of the leaderboard. scopes are the scopes
+// available for the user
+func PrepareTop(entries []*PoolTopEntry, scopes []int, scope, order, offset int, has_next bool, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(locale.Top, TopScopeToStr(scope, locale), TopOrderToStr(order, locale)))
+	b.WriteByte(0xA)
+	if order == TOP_BY_WINRATE {
+		b.WriteString(fmt.Sprintf(locale.TopMinGames, TOP_MIN_GAMES))
+		b.WriteByte(0xA)
+	}
+	b.WriteByte(0xA)
+	if len(entries) == 0 {
+		b.WriteString(locale.TopEmpty)
+	}
+	for i, entry := range entries {
+		var value string
+		switch order {
+		case TOP_BY_WINRATE:
+			value = fmt.Sprintf(locale.TopWinRate, entry.WinRate(), entry.Total)
+		case TOP_BY_RATING:
+			value = fmt.Sprintf(locale.TopRating, entry.Rating.Rating, entry.Rating.RD)
+		default:
+			value = fmt.Sprintf(locale.TopWins, entry.Won, entry.Total)
+		}
+		b.WriteString(fmt.Sprintf(locale.TopItem, offset+i+1,
+			html.EscapeString(entry.Client.GetUserName()), value))
+		b.WriteByte(0xA)
+	}
+
+	top_button := func(caption string, scope, order, offset int) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(caption,
+			fmt.Sprintf("%s&%d&%d&%d", TG_COMMAND_TOP, scope, order, offset))
+	}
+	// the current choice is marked
+	mark := func(caption string, checked bool) string {
+		if checked {
+			return "\U00002705 " + caption
+		}
+		return caption
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, 3)
+	orders := make([]tgbotapi.InlineKeyboardButton, 0, 3)
+	for _, o := range []int{TOP_BY_WINS, TOP_BY_WINRATE, TOP_BY_RATING} {
+		orders = append(orders, top_button(mark(TopOrderToStr(o, locale), o == order), scope, o, 0))
+	}
+	rows = append(rows, orders)
+	if len(scopes) > 1 {
+		row := make([]tgbotapi.InlineKeyboardButton, 0, len(scopes))
+		for _, sc := range scopes {
+			row = append(row, top_button(mark(TopScopeToStr(sc, locale), sc == scope), sc, order, 0))
+		}
+		rows = append(rows, row)
+	}
+	paging := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+	if offset > 0 {
+		paging = append(paging, top_button("\U00002B05", scope, order, max(offset-TOP_PAGE_SIZE, 0)))
+	}
+	if has_next {
+		paging = append(paging, top_button("\U000027A1", scope, order, offset+TOP_PAGE_SIZE))
+	}
+	if len(paging) > 0 {
+		rows = append(rows, paging)
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (handler *BotHandler) SendTop(scope, order, offset int, msg_id int) {
+	// the chat leaderboard has the sense in the group chats only
+	scopes := []int{TOP_SCOPE_GLOBAL}
+	if !handler.Actor.GetID().IsPrivateChat() {
+		scopes = append(scopes, TOP_SCOPE_CHAT)
+	}
+	room := handler.Actor.GetRoom()
+	if room != nil {
+		scopes = append(scopes, TOP_SCOPE_ROOM)
+	}
+	if !slices.Contains(scopes, scope) {
+		handler.ErrorStr = handler.GetLocale().NoActiveRooms
+		return
+	}
+	if order < TOP_BY_WINS || order > TOP_BY_RATING || offset < 0 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	// one more entry to know if the next page exists
+	var entries []*PoolTopEntry
+	var err error
+	switch scope {
+	case TOP_SCOPE_CHAT:
+		entries, err = handler.Actor.GetPool().GetChatTop(handler.GetChatID(), order, offset, TOP_PAGE_SIZE+1)
+	case TOP_SCOPE_ROOM:
+		entries, err = handler.Actor.GetPool().GetRoomTop(room, order, offset, TOP_PAGE_SIZE+1)
+	default:
+		entries, err = handler.Actor.GetPool().GetGlobalTop(order, offset, TOP_PAGE_SIZE+1)
+	}
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	has_next := len(entries) > TOP_PAGE_SIZE
+	if has_next {
+		entries = entries[:TOP_PAGE_SIZE]
+	}
+
+	txt, keyboard := PrepareTop(entries, scopes, scope, order, offset, has_next, handler.GetLocale())
+	handler.sendOrEdit(msg_id, txt, keyboard)
+}
+
+func (handler *BotHandler) HandleTop() {
+	scope := TOP_SCOPE_GLOBAL
+	if !handler.Actor.GetID().IsPrivateChat() {
+		scope = TOP_SCOPE_CHAT
+	}
+	handler.SendTop(scope, TOP_BY_WINS, 0, 0)
+}
+
+func (handler *BotHandler) HandleTopAction(msg_id int) {
+	// scope, order, offset
+	if handler.GetParamCnt() < 3 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	var values [3]int64
+	for i := range values {
+		v, err := handler.GetParamAsInt64(i)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		values[i] = v
+	}
+	handler.SendTop(int(values[0]), int(values[1]), int(values[2]), msg_id)
+}
+
+func GameResultToStr(game *PoolGameRecord, locale *LanguageStrings) string {
+	if game.Won {
+		return locale.HistoryWon
+	}
+	if len(game.FinishedAt) == 0 {
+		return locale.HistoryNotFinished
+	}
+	return locale.HistoryLost
+}
+
+func historyButton(caption string, params ...any) tgbotapi.InlineKeyboardButton {
+	data := TG_COMMAND_HISTORY
+	for _, param := range params {
+		data += fmt.Sprintf("&%v", param)
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(caption, data)
+}
+
+// splitButtons places the buttons in the rows of the given width
+func splitButtons(buttons []tgbotapi.InlineKeyboardButton, width int) [][]tgbotapi.InlineKeyboardButton {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, (len(buttons)+width-1)/width)
+	for len(buttons) > 0 {
+		n := min(width, len(buttons))
+		rows = append(rows, buttons[:n])
+		buttons = buttons[n:]
+	}
+	return rows
+}
+
+func PrepareHistory(games []*PoolGameRecord, offset int, has_next bool, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(locale.History)
+	b.WriteByte(0xA)
+	if len(games) == 0 {
+		b.WriteString(locale.HistoryEmpty)
+	}
+
+	buttons := make([]tgbotapi.InlineKeyboardButton, 0, len(games))
+	for i, game := range games {
+		b.WriteString(fmt.Sprintf(locale.HistoryItem, offset+i+1,
+			html.EscapeString(game.RoomName), game.StartedAt,
+			GameResultToStr(game, locale), game.Rounds, game.Players))
+		b.WriteByte(0xA)
+		buttons = append(buttons, historyButton(strconv.Itoa(offset+i+1), HISTORY_GAME, game.Id))
+	}
+
+	rows := splitButtons(buttons, 5)
+	paging := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+	if offset > 0 {
+		paging = append(paging, historyButton("\U00002B05", HISTORY_LIST, max(offset-HISTORY_PAGE_SIZE, 0)))
+	}
+	if has_next {
+		paging = append(paging, historyButton("\U000027A1", HISTORY_LIST, offset+HISTORY_PAGE_SIZE))
+	}
+	if len(paging) > 0 {
+		rows = append(rows, paging)
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func PrepareGameRecord(game *PoolGameRecord, rounds []*PoolRoundRecord, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(locale.HistoryGame,
+		html.EscapeString(game.RoomName), game.StartedAt, GameResultToStr(game, locale)))
+	b.WriteByte(0xA)
+	b.WriteByte(0xA)
+
+	buttons := make([]tgbotapi.InlineKeyboardButton, 0, min(len(rounds), HISTORY_MAX_ROUNDS))
+	for i, round := range rounds {
+		if i == HISTORY_MAX_ROUNDS {
+			b.WriteString(fmt.Sprintf(locale.HistoryMoreRounds, len(rounds)-i))
+			b.WriteByte(0xA)
+			break
+		}
+		move := html.EscapeString(round.Sign)
+		if round.Skip {
+			move = locale.HistorySkipped
+		}
+		winner := html.EscapeString(round.WinnerSigns)
+		if len(winner) == 0 {
+			winner = locale.HistoryNobody
+		}
+		b.WriteString(fmt.Sprintf(locale.HistoryRound, round.Round, move, winner))
+		b.WriteByte(0xA)
+		buttons = append(buttons, historyButton(strconv.Itoa(round.Round), HISTORY_ROUND, game.Id, round.Round))
+	}
+
+	rows := splitButtons(buttons, 8)
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		historyButton(locale.HistoryBack, HISTORY_LIST, 0),
+	})
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func PrepareRoundRecord(game *PoolGameRecord, round int, moves []*PoolMoveRecord, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(locale.HistoryRoundTitle, round, html.EscapeString(game.RoomName)))
+	b.WriteByte(0xA)
+	b.WriteByte(0xA)
+	for _, move := range moves {
+		sign := html.EscapeString(move.Sign)
+		if move.Skip {
+			sign = locale.HistorySkipped
+		}
+		b.WriteString(fmt.Sprintf("<b>%s</b> %s", html.EscapeString(move.UserName), sign))
+		if move.Eliminated {
+			b.WriteString(" " + locale.HistoryEliminated)
+		}
+		if move.Won {
+			b.WriteString(" " + locale.HistoryWon)
+		}
+		b.WriteByte(0xA)
+	}
+
+	row := make([]tgbotapi.InlineKeyboardButton, 0, 3)
+	if round > 1 {
+		row = append(row, historyButton("\U00002B05", HISTORY_ROUND, game.Id, round-1))
+	}
+	row = append(row, historyButton(locale.HistoryBack, HISTORY_GAME, game.Id))
+	if round < game.Rounds {
+		row = append(row, historyButton("\U000027A1", HISTORY_ROUND, game.Id, round+1))
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+func (handler *BotHandler) sendOrEdit(msg_id int, txt string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	if msg_id != 0 {
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = keyboard
+		handler.Send(msg)
+	}
+}
+
+func (handler *BotHandler) SendHistory(offset int, msg_id int) {
+	// one more game to know if the next page exists
+	games, err := handler.Actor.GetPool().GetHistory(handler.Actor.GetClient(), offset, HISTORY_PAGE_SIZE+1)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	has_next := len(games) > HISTORY_PAGE_SIZE
+	if has_next {
+		games = games[:HISTORY_PAGE_SIZE]
+	}
+	txt, keyboard := PrepareHistory(games, offset, has_next, handler.GetLocale())
+	handler.sendOrEdit(msg_id, txt, keyboard)
+}
+
+func (handler *BotHandler) HandleHistory() {
+	handler.SendHistory(0, 0)
+}
+
+func (handler *BotHandler) HandleHistoryAction(msg_id int) {
+	// action, offset | game_id [, round]
+	if handler.GetParamCnt() < 2 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	value, err := handler.GetParamAsInt64(1)
+	if err != nil || value < 0 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	if handler.Params[0] == HISTORY_LIST {
+		handler.SendHistory(int(value), msg_id)
+		return
+	}
+
+	// the game is shown only to its players
+	game, err := handler.Actor.GetPool().GetGameRecord(handler.Actor.GetClient(), value)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	switch handler.Params[0] {
+	case HISTORY_GAME:
+		rounds, err := handler.Actor.GetPool().GetRoundRecords(handler.Actor.GetClient(), game.Id)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		txt, keyboard := PrepareGameRecord(game, rounds, handler.GetLocale())
+		handler.sendOrEdit(msg_id, txt, keyboard)
+	case HISTORY_ROUND:
+		if handler.GetParamCnt() < 3 {
+			handler.ErrorStr = handler.GetLocale().NoParams
+			return
+		}
+		round, err := handler.GetParamAsInt64(2)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		moves, err := handler.Actor.GetPool().GetMoveRecords(game.Id, int(round))
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		txt, keyboard := PrepareRoundRecord(game, int(round), moves, handler.GetLocale())
+		handler.sendOrEdit(msg_id, txt, keyboard)
+	default:
+		handler.ErrorStr = handler.GetLocale().NoParams
+	}
+}
+
+func (handler *BotHandler) HandleExport() {
+	msg := tgbotapi.NewMessage(handler.GetChatID(), handler.GetLocale().ExportChooseFormat)
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("CSV",
+				fmt.Sprintf("%s&%s", TG_COMMAND_EXPORT, EXPORT_CSV)),
+			tgbotapi.NewInlineKeyboardButtonData("JSON",
+				fmt.Sprintf("%s&%s", TG_COMMAND_EXPORT, EXPORT_JSON)),
+		})
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleExportAction(msg_id int) {
+	// format
+	if handler.GetParamCnt() < 1 || !IsExportFormat(handler.Params[0]) {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	file, err := handler.Actor.GetPool().ExportHistory(handler.Actor.GetClient(), handler.Params[0])
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	// stop the generation when the upload is finished or has failed
+	defer file.Close()
+
+	msg := tgbotapi.NewEditMessageText(handler.GetChatID(), msg_id, handler.GetLocale().ExportSending)
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+
+	doc := tgbotapi.NewDocument(handler.GetChatID(), file)
+	doc.Caption = handler.GetLocale().ExportCaption
+	if handler.Bot != nil {
+		_, err = handler.Bot.Send(doc)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+		}
+	}
+}
+
+func (handler *BotHandler) HandleTransfer() {
+	room := handler.Actor.GetRoom()
+	if room == nil {
+		handler.ErrorStr = handler.GetLocale().NoRoomDetected
+		return
+	}
+	if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+		handler.ErrorStr = handler.GetLocale().NotRoomOwner
+		return
+	}
+
+	hash, err := handler.Actor.GetPool().GetHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	members, err := handler.Actor.GetPool().GetMembers(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(members))
+	for _, mem := range members {
+		if mem.GetID().IsAI() || mem.GetID().Compare(handler.Actor.GetID()) == 0 {
+			continue
+		}
+		rows = append(rows,
+			[]tgbotapi.InlineKeyboardButton{
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf(handler.GetLocale().TransferTo, mem.GetUserName()),
+					fmt.Sprintf("%s&%d&%s", TG_COMMAND_TRANSFER, mem.GetID().user_id, hash)),
+			})
+	}
+	if len(rows) == 0 {
+		handler.ErrorStr = handler.GetLocale().TransferNobody
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().TransferPick, html.EscapeString(room.GetName())))
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleTransferTo() {
+	// user_id, room_hash
+	if handler.GetParamCnt() < 2 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[1])
+	if room == nil {
+		return
+	}
+
+	user_id, err := handler.GetParamAsInt64(0)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	members, err := handler.Actor.GetPool().GetMembers(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	var heir *PoolClient = nil
+	for _, mem := range members {
+		if mem.GetID().user_id == user_id {
+			heir = mem
+			break
+		}
+	}
+	if heir == nil {
+		handler.ErrorStr = handler.GetLocale().TransferNobody
+		return
+	}
+
+	_, err = handler.Actor.GetPool().TransferOwnership(room, heir)
+	if err != nil {
+		if err == ErrOwnerHasRoom {
+			handler.ErrorStr = handler.GetLocale().OwnerHasRoom
+		} else if err == ErrNotMember {
+			handler.ErrorStr = handler.GetLocale().TransferNobody
+		} else {
+			handler.ErrorStr = ErrorToString(err)
+		}
+	}
+}
+
+// PrepareModeration prepares the member picker to kick the members or
+// to ban them (with the list of banned users to unban)
+func PrepareModeration(room *PoolRoom, members []*PoolClient, bans []*PoolClient, hash string, ban bool, locale *LanguageStrings) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	var cmd, caption string
+	if ban {
+		b.WriteString(fmt.Sprintf(locale.BanPick, html.EscapeString(room.GetName())))
+		cmd, caption = TG_COMMAND_BAN, locale.BanMember
+	} else {
+		b.WriteString(fmt.Sprintf(locale.KickPick, html.EscapeString(room.GetName())))
+		cmd, caption = TG_COMMAND_KICK, locale.KickMember
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(members)+len(bans)+1)
+	for _, mem := range members {
+		if mem.GetID().IsAI() || room.GetOwnerID().Compare(mem.GetID()) == 0 {
+			continue
+		}
+		rows = append(rows,
+			[]tgbotapi.InlineKeyboardButton{
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf(caption, mem.GetUserName()),
+					fmt.Sprintf("%s&%d&%s", cmd, mem.GetID().user_id, hash)),
+			})
+	}
+	if len(rows) == 0 {
+		b.WriteByte(0xA)
+		b.WriteString(locale.NoMembers)
+	}
+	if ban && len(bans) > 0 {
+		b.WriteString("\n\n")
+		b.WriteString(locale.BannedList)
+		for _, banned := range bans {
+			b.WriteString(fmt.Sprintf("\n@%s", banned.GetUserName()))
+			rows = append(rows,
+				[]tgbotapi.InlineKeyboardButton{
+					tgbotapi.NewInlineKeyboardButtonData(
+						fmt.Sprintf(locale.UnbanMember, banned.GetUserName()),
+						fmt.Sprintf("%s&%d&%s", TG_COMMAND_UNBAN, banned.GetID().user_id, hash)),
+				})
+		}
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (handler *BotHandler) SendModeration(room *PoolRoom, ban bool, msg_id int) {
+	hash, err := handler.Actor.GetPool().GetHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	members, err := handler.Actor.GetPool().GetMembers(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	bans, err := handler.Actor.GetPool().GetBans(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	txt, keyboard := PrepareModeration(room, members, bans, hash, ban, handler.GetLocale())
+	if msg_id != 0 {
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+		msg.ParseMode = PM_HTML
+		msg.ReplyMarkup = keyboard
+		handler.Send(msg)
+	}
+}
+
+func (handler *BotHandler) HandleModeration(ban bool) {
+	room := handler.Actor.GetRoom()
+	if room == nil {
+		handler.ErrorStr = handler.GetLocale().NoRoomDetected
+		return
+	}
+	if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+		handler.ErrorStr = handler.GetLocale().NotRoomOwner
+		return
+	}
+
+	handler.SendModeration(room, ban, 0)
+}
+
+func (handler *BotHandler) HandleModerationAction(msg_id int) {
+	// [user_id,] room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[handler.GetParamCnt()-1])
+	if room == nil {
+		return
+	}
+
+	ban := *handler.Command != TG_COMMAND_KICK
+	if handler.GetParamCnt() < 2 {
+		// just show the picker
+		handler.SendModeration(room, ban, 0)
+		return
+	}
+
+	user_id, err := handler.GetParamAsInt64(0)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	switch *handler.Command {
+	case TG_COMMAND_KICK:
+		err = handler.Actor.GetPool().KickMember(room, user_id, false)
+	case TG_COMMAND_BAN:
+		err = handler.Actor.GetPool().KickMember(room, user_id, true)
+	default:
+		err = handler.Actor.GetPool().Unban(room, user_id)
+	}
+	// the member could be already removed - just refresh the picker
+	if err != nil && err != ErrNotMember && err != ErrNotInQueue {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	handler.SendModeration(room, ban, msg_id)
+}
+
+func (handler *BotHandler) HandleSetPassword(msg_id int) {
+	// [0,] room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	hash := handler.Params[handler.GetParamCnt()-1]
+	room := handler.getOwnedRoomWithHash(hash)
+	if room == nil {
+		return
+	}
+
+	if handler.GetParamCnt() > 1 {
+		// remove the password
+		err := handler.Actor.GetPool().SetRoomPassword(room, "")
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+
+		txt, keyboard := PrepareRoomSettings(room, hash, handler.GetLocale())
+		msg := tgbotapi.NewEditMessageTextAndMarkup(handler.GetChatID(), msg_id, txt, keyboard)
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().SetPassword,
+			TG_COMMAND_SETPASS, hash))
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = tgbotapi.ForceReply{
+		ForceReply:            true,
+		InputFieldPlaceholder: "password",
+	}
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleSetPasswordInput(pass string, msg_id int) {
+	// room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[0])
+	if room == nil {
+		return
+	}
+
+	handler.Send(tgbotapi.NewDeleteMessage(handler.GetChatID(), msg_id))
+
+	pass = strings.TrimSpace(pass)
+	if len(pass) == 0 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+	err := handler.Actor.GetPool().SetRoomPassword(room, pass)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	handler.SendRoomSettings(room)
+}
+
+func (handler *BotHandler) getOwnedRoomWithHash(hash string) *PoolRoom {
+	room, err := handler.Actor.GetPool().GetRoomWithHash(handler.Actor.GetClient(), hash)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return nil
+	}
+	if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+		handler.ErrorStr = handler.GetLocale().NotRoomOwner
+		return nil
+	}
+	return room
+}
+
+func (handler *BotHandler) HandleCustomRules() {
+	// room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[0])
+	if room == nil {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().SetCustomRules,
+			TG_COMMAND_SETRULES, handler.Params[0]))
+	msg.ParseMode = PM_HTML
+	msg.ReplyMarkup = tgbotapi.ForceReply{
+		ForceReply:            true,
+		InputFieldPlaceholder: "{\"gestures\": []}",
+	}
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleCustomRulesInput(rules_json string) {
+	// room_hash
+	if handler.GetParamCnt() < 1 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[0])
+	if room == nil {
+		return
+	}
+
+	custom := &CustomRules{}
+	err := json.Unmarshal([]byte(rules_json), custom)
+	if err == nil {
+		_, err = custom.Compile()
+	}
+	if err != nil {
+		handler.ErrorStr = fmt.Sprintf(handler.GetLocale().RulesNotValid, ErrorToString(err))
+		return
+	}
+
+	setts := *room.GetRoomSettings()
+	setts.Rules = RULES_CUSTOM
+	setts.Custom = custom
+
+	err = handler.Actor.GetPool().UpdateRoomSettings(room, &setts)
+	if err != nil {
+		if err == ErrAlreadyClosed {
+			handler.ErrorStr = handler.GetLocale().RoomAlreadyClosed
+		} else {
+			handler.ErrorStr = ErrorToString(err)
+		}
+		return
+	}
+
+	handler.SendRoomSettings(room)
+}
+
+func (handler *BotHandler) HandleAddAI() {
+	// strategy, room_hash
+	if handler.GetParamCnt() < 2 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	room := handler.getOwnedRoomWithHash(handler.Params[1])
+	if room == nil {
+		return
+	}
+
+	strategy, err := handler.GetParamAsInt64(0)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	ai, err := handler.Actor.GetPool().AddAIMember(room, int(strategy))
+	if err != nil {
+		if err == ErrAlreadyClosed {
+			handler.ErrorStr = handler.GetLocale().RoomAlreadyClosed
+		} else {
+			handler.ErrorStr = ErrorToString(err)
+		}
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().AIAdded,
+			ai.GetUserName(),
+			AIStrategyToStr(int(strategy), handler.GetLocale()),
+			html.EscapeString(room.GetName())))
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleVerify() {
+	// round, uid, choose, salt, commitment
+	if handler.GetParamCnt() < 5 {
+		handler.ErrorStr = handler.GetLocale().NoParams
+		return
+	}
+
+	round, err := handler.GetParamAsInt64(0)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+	choose, err := handler.GetParamAsInt64(2)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	var txt string
+	commit := Commitment(int(round), handler.Params[1], int(choose), handler.Params[3])
+	if commit == strings.ToLower(handler.Params[4]) {
+		txt = handler.GetLocale().VerifyOk
+	} else {
+		txt = fmt.Sprintf(handler.GetLocale().VerifyFailed, commit)
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(), txt)
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+}
+
+func (handler *BotHandler) HandleNewRoomInput(new_name string) {
+	if handler.Actor.IsAuthorized() {
+		handler.Send(PrepareAuthorized(handler.Actor))
+	} else {
+		// if not authorized
+		room, err := handler.Actor.GetPool().GenRoom(handler.Actor.GetClient(), new_name, true, handler.GetLocale())
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+		err = handler.Actor.GetPool().ResetRoom(room)
+		if err != nil {
+			handler.ErrorStr = ErrorToString(err)
+			return
+		}
+
+		msg := tgbotapi.NewMessage(handler.GetChatID(),
+			fmt.Sprintf(handler.GetLocale().RoomCreated,
+				room.GetName()))
+		msg.ParseMode = PM_HTML
+		handler.Send(msg)
+
+		if !handler.SendInvites(room) {
+			return
+		}
+
+		// let the owner to choose the game rules
+		handler.SendRoomSettings(room)
+	}
+}
+
+// SendInvites sends the join and the spectate invitations for the room
+func (handler *BotHandler) SendInvites(room *PoolRoom) bool {
+	token, err := handler.Actor.GetPool().GetHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return false
+	}
+
+	// gen message to send join invitation
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().JoinRoomInvite,
+			handler.Bot.Self.UserName, TG_COMMAND_JOINROOM[1:], token, room.GetName()))
+	msg.ParseMode = PM_HTML
+
+	handler.Send(msg)
+
+	token, err = handler.Actor.GetPool().GetSpectatorHashForRoom(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return false
+	}
+
+	// gen message to send spectate invitation
+	msg = tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().SpectateRoomInvite,
+			handler.Bot.Self.UserName, TG_COMMAND_JOINROOM[1:], token, room.GetName()))
+	msg.ParseMode = PM_HTML
+
+	handler.Send(msg)
+	return true
+}
+
+func (handler *BotHandler) HandleRevokeInvite(reissue bool) {
+	var room *PoolRoom
+	if handler.GetParamCnt() > 0 {
+		// room_hash
+		room = handler.getOwnedRoomWithHash(handler.Params[0])
+		if room == nil {
+			return
+		}
+	} else {
+		room = handler.Actor.GetRoom()
+		if room == nil {
+			handler.ErrorStr = handler.GetLocale().NoRoomDetected
+			return
+		}
+		if room.GetOwnerID().Compare(handler.Actor.GetID()) != 0 {
+			handler.ErrorStr = handler.GetLocale().NotRoomOwner
+			return
+		}
+	}
+
+	err := handler.Actor.GetPool().RevokeRoomHash(room)
+	if err != nil {
+		handler.ErrorStr = ErrorToString(err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(handler.GetChatID(),
+		fmt.Sprintf(handler.GetLocale().InviteLinksRevoked, room.GetName()))
+	msg.ParseMode = PM_HTML
+	handler.Send(msg)
+
+	if reissue {
+		handler.SendInvites(room)
+	}
+}
+
+func main() {
+	cfgFile, err := os.Open("config.json")
+	check(err)
+	byteValue, err := io.ReadAll(cfgFile)
+	check(err)
+	var bot_cfg BotConfig
+	err = json.Unmarshal(byteValue, &bot_cfg)
+	check(err)
+	cfgFile.Close()
+
+	if len(bot_cfg.CallbackSecret) == 0 {
+		log.Println("Callback secret is not set. The buttons will be invalid after restart")
+	}
+	check(SetCallbackSecret(bot_cfg.CallbackSecret))
+
+	clientpool, err := NewPool(bot_cfg.Database)
+	check(err)
+
+	var bot *tgbotapi.BotAPI
+	// debug cases only
+	if bot_cfg.APIDebug.Enabled {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+
+		if bot_cfg.APIDebug.InsecureSkipVerify {
+			tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client := &http.Client{Transport: tr}
+		bot, err = tgbotapi.NewBotAPIWithClient(bot_cfg.APIDebug.Token, bot_cfg.APIDebug.Endpoint, client)
+	} else {
+		bot, err = tgbotapi.NewBotAPI(bot_cfg.BotToken)
+	}
+	check(err)
+
+	bot.Debug = bot_cfg.Debug
+
+	log.Printf("Authorized on account %s", bot.Self.UserName)
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = bot_cfg.Timeout
 
 	// prepare chans
 	stop := make(chan int)
+	if bot_cfg.Janitor != nil {
+		clientpool.SetJanitorConfig(*bot_cfg.Janitor)
+	}
 	pool_updates := clientpool.GetPoolUpdates()
 	updates := bot.GetUpdatesChan(u)
 	// start TG handler
 	go func() {
 		for update := range pool_updates {
+			if len(update.Params) > 0 {
+				// AI members and the lobby have no chats to send messages to
+				if to_whom, ok := update.Params[0].(*PoolClient); ok &&
+					(to_whom.GetID().IsAI() || to_whom.GetID().IsLobby()) {
+					continue
+				}
+			}
 			switch update.Type {
 			case UPD_CLIENT_DISCONNECT_ROOM:
 				{
@@ -413,7 +2283,7 @@ func main() {
 					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
 					msg.ParseMode = PM_HTML
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
 				}
 			case UPD_CLIENT_CONNECTED_ROOM:
 				{
@@ -435,7 +2305,7 @@ func main() {
 							})
 					}
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
 				}
 			case UPD_ROOM_FINISHED:
 				{
@@ -447,7 +2317,51 @@ func main() {
 					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
 					msg.ParseMode = PM_HTML
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
+				}
+			case UPD_ROOM_IDLE:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var from_room *PoolRoom = update.GetPoolRoom(1)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtRoomIdle, from_room.GetOwnerName(), from_room.GetName())
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_MATCH_FOUND:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtMatchFound, room.GetName())
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_LOBBY_EXPIRED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(),
+						fmt.Sprintf(to_whom.GetLocale().EvtLobbyExpired, TG_COMMAND_FINDGAME))
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_LOBBY_FINISHED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(),
+						fmt.Sprintf(to_whom.GetLocale().EvtLobbyFinished, TG_COMMAND_FINDGAME))
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
 				}
 			case UPD_ROOM_CLOSED:
 				{
@@ -459,7 +2373,7 @@ func main() {
 					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
 					msg.ParseMode = PM_HTML
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
 				}
 			case UPD_YOUR_TURN:
 				{
@@ -468,39 +2382,178 @@ func main() {
 					var turn int64 = update.GetInt(2)
 
 					txt := fmt.Sprintf(to_whom.GetLocale().EvtYourTurn, to_whom.GetUserName())
+					if room.GetRoomSettings().TurnTimeout > 0 {
+						txt += "\n" + fmt.Sprintf(to_whom.GetLocale().EvtTurnDeadline,
+							room.GetRoomSettings().TurnTimeout,
+							TimeoutPolicyToStr(room.GetRoomSettings().TimeoutPolicy, to_whom.GetLocale()))
+					}
+					if room.GetRoomSettings().CommitReveal {
+						members, err := clientpool.GetMembers(room)
+						if err != nil {
+							break
+						}
+						for _, mem := range members {
+							if len(mem.GetPlayer().Commit) > 0 {
+								txt += "\n" + fmt.Sprintf(to_whom.GetLocale().EvtCommitment,
+									mem.GetUserName(), mem.GetPlayer().Commit)
+							}
+						}
+					}
 					hash, err := clientpool.GetHashForRoom(room)
 					if err != nil {
 						break
 					}
 
-					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
-					msg.ParseMode = PM_HTML
-					msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-						[]tgbotapi.InlineKeyboardButton{
-							tgbotapi.NewInlineKeyboardButtonData(
-								fmt.Sprintf(to_whom.GetLocale().ChooseSPS, SIGN_STONE),
-								fmt.Sprintf("%s&%d&%d&%s",
-									TG_COMMAND_CHOOSE, CHOOSE_STONE, turn, hash)),
-						},
-						[]tgbotapi.InlineKeyboardButton{
-							tgbotapi.NewInlineKeyboardButtonData(
-								fmt.Sprintf(to_whom.GetLocale().ChooseSPS, SIGN_SCISSORS),
-								fmt.Sprintf("%s&%d&%d&%s",
-									TG_COMMAND_CHOOSE, CHOOSE_SCISSORS, turn, hash)),
-						},
-						[]tgbotapi.InlineKeyboardButton{
-							tgbotapi.NewInlineKeyboardButtonData(
-								fmt.Sprintf(to_whom.GetLocale().ChooseSPS, SIGN_PAPER),
-								fmt.Sprintf("%s&%d&%d&%s",
-									TG_COMMAND_CHOOSE, CHOOSE_PAPER, turn, hash)),
-						},
+					rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+					for _, gesture := range room.GetRules().Gestures {
+						rows = append(rows,
+							[]tgbotapi.InlineKeyboardButton{
+								tgbotapi.NewInlineKeyboardButtonData(
+									fmt.Sprintf(to_whom.GetLocale().ChooseSPS, gesture.Sign),
+									fmt.Sprintf("%s&%d&%d&%s",
+										TG_COMMAND_CHOOSE, gesture.Choose, turn, hash)),
+							})
+					}
+					rows = append(rows,
 						[]tgbotapi.InlineKeyboardButton{
 							tgbotapi.NewInlineKeyboardButtonData(
 								to_whom.GetLocale().CommandExitRoom,
 								TG_COMMAND_EXITROOM),
 						})
 
-					bot.Send(msg)
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+					msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+					SendSigned(bot, msg)
+				}
+			case UPD_CHOICE_COMMITTED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var choose int64 = update.GetInt(2)
+					var commit string = update.GetString(3)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtChoiceCommitted,
+						html.EscapeString(room.GetRules().GetSign(int(choose))), commit)
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_JOIN_QUEUED, UPD_QUEUE_SESSION_FINISHED, UPD_JOIN_DROPPED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+
+					var txt string
+					switch update.Type {
+					case UPD_JOIN_QUEUED:
+						txt = to_whom.GetLocale().EvtJoinQueued
+					case UPD_QUEUE_SESSION_FINISHED:
+						txt = to_whom.GetLocale().EvtQueueSessionFinished
+					default:
+						txt = to_whom.GetLocale().EvtJoinDropped
+					}
+					txt = fmt.Sprintf(txt, room.GetOwnerName(), html.EscapeString(room.GetName()))
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_MEMBER_QUEUED:
+				{
+					var owner *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var user_name string = update.GetString(2)
+
+					hash, err := clientpool.GetHashForRoom(room)
+					if err != nil {
+						break
+					}
+					pending, err := clientpool.GetPendingMembers(room)
+					if err != nil {
+						break
+					}
+
+					txt, keyboard := PrepareJoinQueue(room, pending, hash, owner.GetLocale())
+					txt = fmt.Sprintf(owner.GetLocale().EvtMemberQueued, user_name) + "\n" + txt
+
+					msg := tgbotapi.NewMessage(owner.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+					msg.ReplyMarkup = keyboard
+
+					SendSigned(bot, msg)
+				}
+			case UPD_AUTOSTART:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var delay int64 = update.GetInt(2)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtAutoStart, room.GetOwnerName(), room.GetName(), delay)
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_AUTOSTART_FAILED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var reason error = update.GetError(2)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtAutoStartFailed, room.GetOwnerName(), room.GetName(),
+						RoomErrorToString(reason, room, to_whom.GetLocale()))
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_KICKED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var banned int64 = update.GetInt(2)
+
+					txt := to_whom.GetLocale().EvtKicked
+					if banned != 0 {
+						txt = to_whom.GetLocale().EvtBanned
+					}
+					txt = fmt.Sprintf(txt, room.GetOwnerName(), room.GetName())
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_OWNER_CHANGED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtOwnerChanged, room.GetOwnerName(), room.GetName())
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
+				}
+			case UPD_TURN_WARNING:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var left int64 = update.GetInt(2)
+
+					txt := fmt.Sprintf(to_whom.GetLocale().EvtTurnWarning, left)
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
 				}
 			case UPD_WAIT_FOR_TURN:
 				{
@@ -518,7 +2571,7 @@ func main() {
 								TG_COMMAND_EXITROOM),
 						})
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
 				}
 			case UPD_SESSION_FINISHED:
 				{
@@ -530,18 +2583,31 @@ func main() {
 						break
 					}
 
-					msg := tgbotapi.NewMessage(owner.GetChatID(), owner.GetLocale().GameFinished)
-					msg.ParseMode = PM_HTML
-					msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-						[]tgbotapi.InlineKeyboardButton{
+					rows := [][]tgbotapi.InlineKeyboardButton{
+						{
 							tgbotapi.NewInlineKeyboardButtonData(
 								fmt.Sprintf(owner.GetLocale().CommandRestartRoom,
 									room.GetName()),
 								fmt.Sprintf("%s&%s",
 									TG_COMMAND_RESTARTROOM, hash)),
-						})
+						},
+					}
+					pending, err := clientpool.GetPendingMembers(room)
+					if err == nil && len(pending) > 0 {
+						rows = append(rows,
+							[]tgbotapi.InlineKeyboardButton{
+								tgbotapi.NewInlineKeyboardButtonData(
+									fmt.Sprintf(owner.GetLocale().CommandJoinQueue, len(pending)),
+									fmt.Sprintf("%s&%s&%s",
+										TG_COMMAND_QUEUE, QUEUE_SHOW, hash)),
+							})
+					}
 
-					bot.Send(msg)
+					msg := tgbotapi.NewMessage(owner.GetChatID(), owner.GetLocale().GameFinished)
+					msg.ParseMode = PM_HTML
+					msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+					SendSigned(bot, msg)
 				}
 			case UPD_ROUND_FINISHED:
 				{
@@ -550,48 +2616,109 @@ func main() {
 					var room *PoolRoom = update.GetPoolRoom(1)
 					var winner int64 = update.GetInt(2)
 					var prev_state int64 = update.GetInt(3)
+					var game *PoolGame = update.GetPoolGame(4)
+					var draw_strategy int64 = update.GetInt(5)
+					var reveals []PoolReveal = update.GetReveals(6)
+					var changes map[TgUserId]PoolRatingChange = update.GetRatingChanges(7)
 
 					members, err := clientpool.GetMembers(room)
 					if err != nil {
 						break
 					}
 
+					scoring := room.GetRoomSettings().GetMode() == MODE_SCORING
+
 					var title string
-					if prev_state == PST_WATCHING {
+					if scoring {
+						title = fmt.Sprintf(to_whom.GetLocale().RResScoring,
+							game.Round, room.GetRoomSettings().GetRounds(), to_whom.GetPlayer().Points)
+					} else if prev_state == PST_WATCHING || to_whom.GetPlayer().Skip {
 						title = to_whom.GetLocale().RResRoundFinished
 					} else {
 						if winner == 0 {
 							title = to_whom.GetLocale().RResWinNobody
-						} else if to_whom.GetPlayer().State == PST_PLAYING {
+						} else if int64(to_whom.GetPlayer().Choose)&winner != 0 {
 							title = to_whom.GetLocale().RResYouWin
+						} else if to_whom.GetPlayer().State == PST_PLAYING {
+							title = fmt.Sprintf(to_whom.GetLocale().RResYouLooseLife, to_whom.GetPlayer().Lives)
 						} else {
 							title = to_whom.GetLocale().RResYouLoose
 						}
 					}
+					lives := room.GetRoomSettings().GetLives()
+					if scoring {
+						lives = 1
+					}
+
+					match_wins := room.GetRoomSettings().GetMatchWins()
 
 					var b strings.Builder
 					b.WriteString(title)
 					b.WriteByte(0xA)
+					if draw_strategy != DRAW_NONE {
+						var signs string
+						for _, gesture := range room.GetRules().Gestures {
+							if int64(gesture.Choose)&winner != 0 {
+								signs += html.EscapeString(gesture.Sign)
+							}
+						}
+						b.WriteString(fmt.Sprintf(to_whom.GetLocale().DrawResolved,
+							DrawStrategyToStr(int(draw_strategy), to_whom.GetLocale()), signs))
+						b.WriteByte(0xA)
+					}
+					if match_wins > 1 {
+						b.WriteString(fmt.Sprintf(to_whom.GetLocale().MatchSession, game.Session, match_wins))
+						b.WriteByte(0xA)
+					}
 					b.WriteByte(0xA)
+					spectators := 0
 					for _, mem := range members {
-						b.WriteString(fmt.Sprintf("<b>%s</b> (%s)\n",
+						if mem.GetPlayer().Spectator {
+							spectators++
+							continue
+						}
+						b.WriteString(fmt.Sprintf("<b>%s</b> (%s)",
 							mem.GetUserName(), PSTToStr(mem.GetPlayer().State, mem.GetLocale())))
+						if scoring {
+							b.WriteString(fmt.Sprintf(" \U0001F4AF %d (+%d)", mem.GetPlayer().Score, mem.GetPlayer().Points))
+						}
+						if match_wins > 1 {
+							b.WriteString(fmt.Sprintf(" \U0001F3C6 %d/%d", game.GetWins(mem.GetID()), match_wins))
+						}
+						if change, ok := changes[*mem.GetID()]; ok {
+							b.WriteString(fmt.Sprintf(" \U0001F4C8 %.0f (%+d)", change.New.Rating, change.Delta()))
+						}
+						b.WriteByte(0xA)
 
+						if lives > 1 {
+							b.WriteString(strings.Repeat("\U00002764", mem.GetPlayer().Lives))
+							b.WriteString(strings.Repeat("\U0001F5A4", max(lives-mem.GetPlayer().Lives, 0)))
+							b.WriteByte(' ')
+						}
 						chooses := mem.GetPlayer().Chooses
 						if len(chooses) > 0 {
 							for _, choose := range chooses {
-								switch choose {
-								case CHOOSE_STONE:
-									b.WriteString(SIGN_STONE)
-								case CHOOSE_SCISSORS:
-									b.WriteString(SIGN_SCISSORS)
-								case CHOOSE_PAPER:
-									b.WriteString(SIGN_PAPER)
-								}
+								b.WriteString(html.EscapeString(room.GetRules().GetSign(choose)))
 							}
 						}
 						b.WriteByte(0xA)
 					}
+					if spectators > 0 {
+						b.WriteString(fmt.Sprintf(to_whom.GetLocale().RResSpectators, spectators))
+						b.WriteByte(0xA)
+					}
+
+					if len(reveals) > 0 {
+						// reveal the salts to verify the recorded choices
+						b.WriteByte(0xA)
+						b.WriteString(to_whom.GetLocale().RResReveal)
+						for _, reveal := range reveals {
+							b.WriteString(fmt.Sprintf("\n<b>%s</b>: <code>%s %d %s %d %s %s</code>",
+								reveal.Client.GetUserName(), TG_COMMAND_VERIFY, reveal.Round,
+								CommitmentUID(reveal.Client.GetID()), reveal.Choose, reveal.Salt, reveal.Commit))
+						}
+						b.WriteByte(0xA)
+					}
 
 					msg := tgbotapi.NewMessage(to_whom.GetChatID(), b.String())
 					msg.ParseMode = PM_HTML
@@ -602,7 +2729,27 @@ func main() {
 								TG_COMMAND_EXITROOM),
 						})
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
+				}
+			case UPD_MATCH_FINISHED:
+				{
+					var to_whom *PoolClient = update.GetPoolClient(0)
+					var room *PoolRoom = update.GetPoolRoom(1)
+					var winner *PoolClient = update.GetPoolClient(2)
+					var game *PoolGame = update.GetPoolGame(3)
+
+					var txt string
+					if winner != nil {
+						txt = fmt.Sprintf(to_whom.GetLocale().EvtMatchWon,
+							winner.GetUserName(), game.GetWins(winner.GetID()), room.GetName())
+					} else {
+						txt = fmt.Sprintf(to_whom.GetLocale().EvtMatchNoWinner, room.GetName())
+					}
+
+					msg := tgbotapi.NewMessage(to_whom.GetChatID(), txt)
+					msg.ParseMode = PM_HTML
+
+					SendSigned(bot, msg)
 				}
 			case UPD_YOU_WIN:
 				{
@@ -610,7 +2757,7 @@ func main() {
 					msg := tgbotapi.NewMessage(winner.GetChatID(), winner.GetLocale().Congratulations)
 					msg.ParseMode = PM_HTML
 
-					bot.Send(msg)
+					SendSigned(bot, msg)
 				}
 			}
 		}
@@ -633,8 +2780,21 @@ func main() {
 				}
 
 				if update.CallbackQuery != nil { // If we got a callback
-					comm, params := ParseCommand(update.CallbackQuery.Data)
+					var chat_id int64 = actor.GetChatID()
+					if update.CallbackQuery.Message != nil {
+						chat_id = update.CallbackQuery.Message.Chat.ID
+					}
+					data, ok := VerifyCallbackData(chat_id, update.CallbackQuery.Data)
+					if !ok {
+						// forged or signed with the outdated secret
+						bot.Send(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID,
+							actor.GetLocale().CallbackInvalid))
+						continue
+					}
+
+					comm, params := ParseCommand(data)
 					handler := NewCommandHandler(bot, actor, &comm, params)
+					var alert string
 
 					switch comm {
 					case TG_COMMAND_CHOOSE:
@@ -657,7 +2817,12 @@ func main() {
 									handler.ErrorStr = ErrorToString(err)
 									break
 								}
-								clientpool.UpdateMemberChoose(actor.GetClient(), room, turn_num, int(choose_id))
+								err = clientpool.UpdateMemberChoose(actor.GetClient(), room, turn_num, int(choose_id))
+								if err == ErrStaleRound {
+									alert = handler.GetLocale().CallbackStale
+								} else if err == ErrChoiceCommitted {
+									alert = handler.GetLocale().ChoiceCommitted
+								}
 							}
 						}
 					case TG_COMMAND_RESTARTROOM:
@@ -673,26 +2838,74 @@ func main() {
 								if room.GetOwnerID().Compare(handler.Actor.GetID()) == 0 {
 									err = clientpool.RestartRoom(room)
 									if err != nil {
-										if err == ErrNoActiveRooms {
-											handler.ErrorStr = handler.GetLocale().NoActiveRooms
-										} else {
-											handler.ErrorStr = ErrorToString(err)
-										}
+										handler.ErrorStr = RoomErrorToString(err, room, handler.GetLocale())
 									}
 								}
 							}
 						}
+					case TG_COMMAND_SETVALUE:
+						{
+							handler.HandleSetRoomSetting(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_SETRULES:
+						{
+							handler.HandleCustomRules()
+						}
+					case TG_COMMAND_ADDAI:
+						{
+							handler.HandleAddAI()
+						}
+					case TG_COMMAND_QUEUE:
+						{
+							handler.HandleJoinQueueAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_TRANSFER:
+						{
+							handler.HandleTransferTo()
+						}
+					case TG_COMMAND_KICK, TG_COMMAND_BAN, TG_COMMAND_UNBAN:
+						{
+							handler.HandleModerationAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_SETPASS:
+						{
+							handler.HandleSetPassword(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_NEWINVITE:
+						{
+							handler.HandleRevokeInvite(true)
+						}
+					case TG_COMMAND_MYROOMS:
+						{
+							handler.HandleMyRoomsAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_FINDGAME:
+						{
+							handler.HandleFindGameAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_TOP:
+						{
+							handler.HandleTopAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_HISTORY:
+						{
+							handler.HandleHistoryAction(update.CallbackQuery.Message.MessageID)
+						}
+					case TG_COMMAND_EXPORT:
+						{
+							// the upload of the large history takes a while
+							msg_id := update.CallbackQuery.Message.MessageID
+							handler.Detach(func(h *BotHandler) {
+								h.HandleExportAction(msg_id)
+							})
+						}
 					case TG_COMMAND_CLOSEROOM:
 						{
 							if handler.Actor.GetRoom() != nil {
 								if handler.Actor.GetID().Compare(handler.Actor.GetRoom().GetOwnerID()) == 0 {
 									err := clientpool.CloseRoom(handler.Actor.GetRoom())
 									if err != nil {
-										if err == ErrAlreadyClosed {
-											handler.ErrorStr = handler.GetLocale().RoomAlreadyClosed
-										} else {
-											handler.ErrorStr = ErrorToString(err)
-										}
+										handler.ErrorStr = RoomErrorToString(err, handler.Actor.GetRoom(), handler.GetLocale())
 									}
 								}
 							}
@@ -709,7 +2922,11 @@ func main() {
 					}
 					error_str = handler.ErrorStr
 
-					bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+					if len(alert) > 0 {
+						bot.Send(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, alert))
+					} else {
+						bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+					}
 				} else if update.Message != nil { // If we got a message
 
 					comm, params := ParseCommand(update.Message.Text)
@@ -741,6 +2958,58 @@ func main() {
 						{
 							handler.HandleGetStat()
 						}
+					case TG_COMMAND_SETT:
+						{
+							handler.HandleRoomSettings()
+						}
+					case TG_COMMAND_VERIFY:
+						{
+							handler.HandleVerify()
+						}
+					case TG_COMMAND_QUEUE:
+						{
+							handler.HandleJoinQueue()
+						}
+					case TG_COMMAND_TRANSFER:
+						{
+							handler.HandleTransfer()
+						}
+					case TG_COMMAND_KICK:
+						{
+							handler.HandleModeration(false)
+						}
+					case TG_COMMAND_BAN:
+						{
+							handler.HandleModeration(true)
+						}
+					case TG_COMMAND_REVOKE:
+						{
+							handler.HandleRevokeInvite(false)
+						}
+					case TG_COMMAND_NEWINVITE:
+						{
+							handler.HandleRevokeInvite(true)
+						}
+					case TG_COMMAND_MYROOMS:
+						{
+							handler.HandleMyRooms()
+						}
+					case TG_COMMAND_FINDGAME:
+						{
+							handler.HandleFindGame()
+						}
+					case TG_COMMAND_TOP:
+						{
+							handler.HandleTop()
+						}
+					case TG_COMMAND_HISTORY:
+						{
+							handler.HandleHistory()
+						}
+					case TG_COMMAND_EXPORT:
+						{
+							handler.HandleExport()
+						}
 					case TG_COMMAND_EXITROOM:
 						{
 							if handler.Actor.GetRoom() != nil {
@@ -765,6 +3034,29 @@ func main() {
 										{
 											handler.HandleNewRoomInput(update.Message.Text)
 										}
+									case TG_COMMAND_SETRULES:
+										{
+											handler.HandleCustomRulesInput(update.Message.Text)
+										}
+									case TG_COMMAND_SETPASS:
+										{
+											// the password hashing is slow
+											text, msg_id := update.Message.Text, update.Message.MessageID
+											handler.Detach(func(h *BotHandler) {
+												h.HandleSetPasswordInput(text, msg_id)
+											})
+										}
+									case TG_COMMAND_JOINPASS:
+										{
+											text, msg_id := update.Message.Text, update.Message.MessageID
+											handler.Detach(func(h *BotHandler) {
+												h.HandleJoinPasswordInput(text, msg_id)
+											})
+										}
+									case TG_COMMAND_RENAME:
+										{
+											handler.HandleRenameRoomInput(update.Message.Text)
+										}
 									}
 								}
 							} else {
@@ -0,0 +1,41 @@
+/*===============================================================*/
+/* The SPS Bot (bot handler tests)                               */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUserIdParamRoundTrip(t *testing.T) {
+	ids := []TgUserId{
+		NewUserId(123456789, 123456789),
+		NewUserId(7123456789, -1001234567890),
+		NewUserId(42, -4242),
+		NewUserId(-1, 0),
+	}
+	for _, id := range ids {
+		data := fmt.Sprintf("%s&%s&%s&%s", TG_COMMAND_QUEUE, QUEUE_APPROVE, FormatUserIdParam(&id), "0123456789abcdef")
+		signed := SignCallbackData(id.GetChatID(), data)
+		if len(signed) > MAX_CALLBACK_DATA_LEN {
+			t.Errorf("%v: the callback data is too long: %d", id, len(signed))
+		}
+
+		cmd, params := ParseCommand(data)
+		if cmd != TG_COMMAND_QUEUE {
+			t.Fatalf("%v: unexpected command %q", id, cmd)
+		}
+		handler := &BotHandler{Params: params}
+		got, err := handler.GetParamAsUserId(1)
+		if err != nil {
+			t.Fatalf("%v: %v", id, err)
+		}
+		if got.Compare(&id) != 0 {
+			t.Errorf("got %v, want %v", got, id)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+/*===============================================================*/
+/* The SPS Bot (player ratings)                                  */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"database/sql"
+	"math"
+)
+
+// Glicko-2 rating system. Every finished session is the rating period
+// where each player meets all the other players of the session
+
+const RATING_INIT = 1500.0
+const RATING_INIT_RD = 350.0
+const RATING_INIT_VOL = 0.06
+
+// the system constant constrains the change of the volatility
+const RATING_TAU = 0.5
+
+// the scale between the Glicko and the Glicko-2 values
+const RATING_SCALE = 173.7178
+
+const RATING_EPS = 0.000001
+
+type PlayerRating struct {
+	Rating float64
+	RD     float64
+	Vol    float64
+}
+
+func DefaultRating() PlayerRating {
+	return PlayerRating{
+		Rating: RATING_INIT,
+		RD:     RATING_INIT_RD,
+		Vol:    RATING_INIT_VOL,
+	}
+}
+
+// PoolRatingChange is the rating of the player before and after the session
+type PoolRatingChange struct {
+	Old PlayerRating
+	New PlayerRating
+}
+
+func (change *PoolRatingChange) Delta() int {
+	return int(math.Round(change.New.Rating)) - int(math.Round(change.Old.Rating))
+}
+
+type ratingResult struct {
+	opponent PlayerRating
+	// 1 for the win, 0.5 for the draw and 0 for the loss
+	score float64
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu, mu_j, phi_j float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(phi_j)*(mu-mu_j)))
+}
+
+// glickoVolatility finds the new volatility with the Illinois algorithm
+func glickoVolatility(phi, vol, delta, v float64) float64 {
+	a := math.Log(vol * vol)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(RATING_TAU*RATING_TAU)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*RATING_TAU) < 0 {
+			k++
+		}
+		B = a - k*RATING_TAU
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > RATING_EPS {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
+
+// Update returns the rating of the player after the rating period
+func (rating PlayerRating) Update(results []ratingResult) PlayerRating {
+	mu := (rating.Rating - RATING_INIT) / RATING_SCALE
+	phi := rating.RD / RATING_SCALE
+
+	if len(results) == 0 {
+		// the player did not play - only the deviation grows
+		phi = math.Sqrt(phi*phi + rating.Vol*rating.Vol)
+		rating.RD = min(phi*RATING_SCALE, RATING_INIT_RD)
+		return rating
+	}
+
+	var v_inv, delta_sum float64
+	for _, res := range results {
+		mu_j := (res.opponent.Rating - RATING_INIT) / RATING_SCALE
+		phi_j := res.opponent.RD / RATING_SCALE
+		g := glickoG(phi_j)
+		e := glickoE(mu, mu_j, phi_j)
+		v_inv += g * g * e * (1 - e)
+		delta_sum += g * (res.score - e)
+	}
+	v := 1 / v_inv
+	delta := v * delta_sum
+
+	vol := glickoVolatility(phi, rating.Vol, delta, v)
+	phi_star := math.Sqrt(phi*phi + vol*vol)
+	phi_new := 1 / math.Sqrt(1/(phi_star*phi_star)+1/v)
+	mu_new := mu + phi_new*phi_new*delta_sum
+
+	return PlayerRating{
+		Rating: mu_new*RATING_SCALE + RATING_INIT,
+		RD:     min(phi_new*RATING_SCALE, RATING_INIT_RD),
+		Vol:    vol,
+	}
+}
+
+// RateSession calculates the new ratings of the players. The players
+// with the greater rank beat the players with the lesser one, the equal
+// ranks are the draw
+func RateSession(ratings []PlayerRating, ranks []int) []PlayerRating {
+	result := make([]PlayerRating, len(ratings))
+	for i, rating := range ratings {
+		results := make([]ratingResult, 0, len(ratings)-1)
+		for j, opponent := range ratings {
+			if i == j {
+				continue
+			}
+			res := ratingResult{opponent: opponent, score: 0.5}
+			if ranks[i] > ranks[j] {
+				res.score = 1
+			} else if ranks[i] < ranks[j] {
+				res.score = 0
+			}
+			results = append(results, res)
+		}
+		result[i] = rating.Update(results)
+	}
+	return result
+}
+
+func (pool *Pool) GetUserRating(id *TgUserId) (PlayerRating, error) {
+	cols, err := pool.getuserrating_stmt.DoSelectRow(
+		[]any{id.user_id, id.chat_id},
+		[]variantParam{RATING_COL, RATING_RD_COL, RATING_VOL_COL})
+	if err == sql.ErrNoRows {
+		return DefaultRating(), nil
+	}
+	if err != nil {
+		return PlayerRating{}, err
+	}
+	return PlayerRating{
+		Rating: cols[RATING_COL.name].(float64),
+		RD:     cols[RATING_RD_COL.name].(float64),
+		Vol:    cols[RATING_VOL_COL.name].(float64),
+	}, nil
+}
+
+// commitSession writes the results of the finished session in one
+// transaction: the statistics of the winners, the losers and the match
+// winner, the new ratings of the players, the players of the room, the
+// result of the game in the history and the room state with the match
+// wins. rank orders the players by the result. Returns the rating
+// changes by the member id and true if the match is finished
+/* important! call only with choose_mux locked */
+func (pool *Pool) commitSession(room *PoolRoom, state *PoolGame, members, winners, losers []*PoolClient, rank func(*PoolClient) int) (map[TgUserId]PoolRatingChange, bool, error) {
+	// only the humans who made a move are rated. AI members have no
+	// statistics and the late joiners have not played the session
+	rated := make([]*PoolClient, 0, len(members))
+	ratings := make([]PlayerRating, 0, len(members))
+	ranks := make([]int, 0, len(members))
+	for _, mem := range members {
+		if mem.id.IsAI() || mem.player.Spectator || len(mem.player.Chooses) == 0 {
+			continue
+		}
+		rating, err := pool.GetUserRating(&mem.id)
+		if err != nil {
+			return nil, false, err
+		}
+		rated = append(rated, mem)
+		ratings = append(ratings, rating)
+		ranks = append(ranks, rank(mem))
+	}
+
+	changes := make(map[TgUserId]PoolRatingChange)
+	var new_ratings []PlayerRating
+	if len(rated) > 1 {
+		new_ratings = RateSession(ratings, ranks)
+		for i, mem := range rated {
+			changes[mem.id] = PoolRatingChange{Old: ratings[i], New: new_ratings[i]}
+		}
+	}
+
+	tx, err := pool.client_db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, stat := range []struct {
+		stmt    *StmtWrapper
+		clients []*PoolClient
+	}{
+		{pool.incuserstatw_stmt, winners},
+		{pool.incuserstatt_stmt, losers},
+	} {
+		for _, client := range stat.clients {
+			if client.id.IsAI() {
+				continue
+			}
+			err = stat.stmt.DoUpdateTx(tx, []any{client.id.user_id, client.id.chat_id})
+			if err != nil {
+				tx.Rollback()
+				return nil, false, err
+			}
+		}
+	}
+	for _, mem := range rated {
+		err = pool.addplayer_stmt.DoUpdateTx(tx, []any{
+			room.ownerid.user_id,
+			room.ownerid.chat_id,
+			room.name,
+			mem.id.user_id,
+			mem.id.chat_id})
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+	}
+	for i, rating := range new_ratings {
+		err = pool.upduserrating_stmt.DoUpdateTx(tx, []any{
+			rated[i].id.user_id,
+			rated[i].id.chat_id,
+			rating.Rating,
+			rating.RD,
+			rating.Vol})
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+	}
+	err = pool.recordGameResultTx(tx, state, winners)
+	if err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	match_finished := pool.addMatchWin(room, state, winners)
+	if match_finished && room.GetRoomSettings().GetMatchWins() > 1 &&
+		len(winners) == 1 && !winners[0].id.IsAI() {
+		err = pool.incuserstatm_stmt.DoUpdateTx(tx, []any{winners[0].id.user_id, winners[0].id.chat_id})
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+	}
+	err = pool.updateRoomStateTx(tx, room, state)
+	if err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+	return changes, match_finished, nil
+}
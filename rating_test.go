@@ -0,0 +1,106 @@
+/*===============================================================*/
+/* The SPS Bot (player ratings tests)                            */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+// the example from the description of the Glicko-2 system by Glickman
+func TestPlayerRatingUpdate(t *testing.T) {
+	rating := PlayerRating{Rating: 1500, RD: 200, Vol: 0.06}
+	got := rating.Update([]ratingResult{
+		{opponent: PlayerRating{Rating: 1400, RD: 30, Vol: 0.06}, score: 1},
+		{opponent: PlayerRating{Rating: 1550, RD: 100, Vol: 0.06}, score: 0},
+		{opponent: PlayerRating{Rating: 1700, RD: 300, Vol: 0.06}, score: 0},
+	})
+	if !approxEqual(got.Rating, 1464.06, 0.05) ||
+		!approxEqual(got.RD, 151.52, 0.05) ||
+		!approxEqual(got.Vol, 0.05999, 0.00001) {
+		t.Errorf("got %+v, want {1464.06 151.52 0.05999}", got)
+	}
+}
+
+func TestPlayerRatingIdle(t *testing.T) {
+	rating := PlayerRating{Rating: 1600, RD: 100, Vol: 0.06}
+	got := rating.Update(nil)
+	if got.Rating != rating.Rating || got.RD <= rating.RD {
+		t.Errorf("got %+v, the deviation only should grow", got)
+	}
+	if got = DefaultRating().Update(nil); got.RD != RATING_INIT_RD {
+		t.Errorf("got the deviation %v, want no more than %v", got.RD, RATING_INIT_RD)
+	}
+}
+
+func TestRateSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratings []PlayerRating
+		ranks   []int
+		// the indexes of the players from the best to the worst rating
+		order []int
+	}{
+		{"duel", []PlayerRating{DefaultRating(), DefaultRating()}, []int{1, 0}, []int{0, 1}},
+		{"duel reversed", []PlayerRating{DefaultRating(), DefaultRating()}, []int{0, 1}, []int{1, 0}},
+		{"three", []PlayerRating{DefaultRating(), DefaultRating(), DefaultRating()}, []int{0, 2, 1}, []int{1, 2, 0}},
+		{"eliminated", []PlayerRating{DefaultRating(), DefaultRating(), DefaultRating()}, []int{3, 3, -1}, []int{0, 2}},
+		{"upset", []PlayerRating{
+			{Rating: 1800, RD: 50, Vol: 0.06},
+			{Rating: 1400, RD: 50, Vol: 0.06}}, []int{0, 1}, nil},
+	}
+
+	for _, test := range tests {
+		got := RateSession(test.ratings, test.ranks)
+		if len(got) != len(test.ratings) {
+			t.Fatalf("%s: got %d ratings, want %d", test.name, len(got), len(test.ratings))
+		}
+		for i := 1; i < len(test.order); i++ {
+			if got[test.order[i-1]].Rating <= got[test.order[i]].Rating {
+				t.Errorf("%s: player %d is not ahead of player %d: %+v", test.name, test.order[i-1], test.order[i], got)
+			}
+		}
+		for i := range got {
+			// the surprising result could widen the narrow deviation,
+			// but the new players always become more certain
+			if test.ratings[i].RD == RATING_INIT_RD && got[i].RD >= test.ratings[i].RD {
+				t.Errorf("%s: the deviation of player %d grows: %+v", test.name, i, got[i])
+			}
+			won, lost := false, false
+			for j := range test.ranks {
+				won = won || test.ranks[i] > test.ranks[j]
+				lost = lost || test.ranks[i] < test.ranks[j]
+			}
+			if won && !lost && got[i].Rating <= test.ratings[i].Rating {
+				t.Errorf("%s: the rating of the winner %d drops: %+v", test.name, i, got[i])
+			}
+			if lost && !won && got[i].Rating >= test.ratings[i].Rating {
+				t.Errorf("%s: the rating of the loser %d grows: %+v", test.name, i, got[i])
+			}
+		}
+	}
+}
+
+func TestRateSessionDraw(t *testing.T) {
+	ratings := []PlayerRating{DefaultRating(), DefaultRating(), DefaultRating()}
+	got := RateSession(ratings, []int{1, 1, 1})
+	for i := range got {
+		if !approxEqual(got[i].Rating, RATING_INIT, 0.001) {
+			t.Errorf("player %d: got %v, the draw of the equals keeps the rating", i, got[i].Rating)
+		}
+	}
+
+	// the duel of the equals is zero-sum
+	got = RateSession([]PlayerRating{DefaultRating(), DefaultRating()}, []int{1, 0})
+	if !approxEqual(got[0].Rating-RATING_INIT, RATING_INIT-got[1].Rating, 0.001) {
+		t.Errorf("got %+v, want the symmetric change", got)
+	}
+}
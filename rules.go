@@ -0,0 +1,304 @@
+/*===============================================================*/
+/* The SPS Bot (game rules)                                      */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const RULES_CLASSIC = 0
+const RULES_LIZARD_SPOCK = 1
+const RULES_CNT = 2
+const RULES_CUSTOM = RULES_CNT
+
+const MAX_CUSTOM_GESTURES = 15
+
+// the limits keep the gestures fit the inline keyboards
+const MAX_GESTURE_NAME_LEN = 20
+const MAX_GESTURE_SIGN_LEN = 8
+
+var ErrRulesTooFew error = fmt.Errorf("at least 3 gestures required")
+var ErrRulesTooMany error = fmt.Errorf("no more than %d gestures allowed", MAX_CUSTOM_GESTURES)
+var ErrRulesEven error = fmt.Errorf("the number of gestures must be odd")
+
+/* GameRules decl */
+
+type GameGesture struct {
+	Choose int
+	Name   string
+	Sign   string
+	Beats  int
+}
+
+type GameRules struct {
+	Gestures []GameGesture
+}
+
+var CLASSIC_RULES = GameRules{
+	Gestures: []GameGesture{
+		{Choose: CHOOSE_STONE, Sign: SIGN_STONE, Beats: CHOOSE_SCISSORS},
+		{Choose: CHOOSE_SCISSORS, Sign: SIGN_SCISSORS, Beats: CHOOSE_PAPER},
+		{Choose: CHOOSE_PAPER, Sign: SIGN_PAPER, Beats: CHOOSE_STONE},
+	},
+}
+
+var LIZARD_SPOCK_RULES = GameRules{
+	Gestures: []GameGesture{
+		{Choose: CHOOSE_STONE, Sign: SIGN_STONE, Beats: CHOOSE_SCISSORS | CHOOSE_LIZARD},
+		{Choose: CHOOSE_SCISSORS, Sign: SIGN_SCISSORS, Beats: CHOOSE_PAPER | CHOOSE_LIZARD},
+		{Choose: CHOOSE_PAPER, Sign: SIGN_PAPER, Beats: CHOOSE_STONE | CHOOSE_SPOCK},
+		{Choose: CHOOSE_LIZARD, Sign: SIGN_LIZARD, Beats: CHOOSE_PAPER | CHOOSE_SPOCK},
+		{Choose: CHOOSE_SPOCK, Sign: SIGN_SPOCK, Beats: CHOOSE_STONE | CHOOSE_SCISSORS},
+	},
+}
+
+/* CustomRules decl */
+
+type CustomGesture struct {
+	Name  string   `json:"name"`
+	Sign  string   `json:"sign"`
+	Beats []string `json:"beats"`
+}
+
+type CustomRules struct {
+	Gestures []CustomGesture `json:"gestures"`
+}
+
+func GetRules(variant int) *GameRules {
+	switch variant {
+	case RULES_LIZARD_SPOCK:
+		return &LIZARD_SPOCK_RULES
+	}
+	return &CLASSIC_RULES
+}
+
+func RulesToStr(variant int, locale *LanguageStrings) string {
+	switch variant {
+	case RULES_LIZARD_SPOCK:
+		return locale.RulesLizardSpock
+	case RULES_CUSTOM:
+		return locale.RulesCustom
+	default:
+		return locale.RulesClassic
+	}
+}
+
+/* CustomRules impl */
+
+// Compile checks that the gestures form a balanced tournament (every
+// pair of gestures has exactly one winner and every gesture beats
+// exactly half of the others) and converts them to the game rules
+func (custom *CustomRules) Compile() (*GameRules, error) {
+	cnt := len(custom.Gestures)
+	if cnt < 3 {
+		return nil, ErrRulesTooFew
+	}
+	if cnt > MAX_CUSTOM_GESTURES {
+		return nil, ErrRulesTooMany
+	}
+	if cnt%2 == 0 {
+		return nil, ErrRulesEven
+	}
+
+	chooses := make(map[string]int)
+	for i, gesture := range custom.Gestures {
+		name := strings.TrimSpace(gesture.Name)
+		sign := strings.TrimSpace(gesture.Sign)
+		if len(name) == 0 || len(sign) == 0 {
+			return nil, fmt.Errorf("gesture #%d has no name or sign", i+1)
+		}
+		if utf8.RuneCountInString(name) > MAX_GESTURE_NAME_LEN {
+			return nil, fmt.Errorf("gesture #%d name is longer than %d symbols", i+1, MAX_GESTURE_NAME_LEN)
+		}
+		if utf8.RuneCountInString(sign) > MAX_GESTURE_SIGN_LEN {
+			return nil, fmt.Errorf("gesture #%d sign is longer than %d symbols", i+1, MAX_GESTURE_SIGN_LEN)
+		}
+		if _, ok := chooses[name]; ok {
+			return nil, fmt.Errorf("gesture \"%s\" is duplicated", name)
+		}
+		chooses[name] = 1 << i
+	}
+
+	rules := &GameRules{Gestures: make([]GameGesture, cnt)}
+	for i, gesture := range custom.Gestures {
+		name := strings.TrimSpace(gesture.Name)
+		beats := 0
+		for _, loser := range gesture.Beats {
+			choose, ok := chooses[strings.TrimSpace(loser)]
+			if !ok {
+				return nil, fmt.Errorf("gesture \"%s\" beats unknown gesture \"%s\"", name, loser)
+			}
+			if choose == 1<<i {
+				return nil, fmt.Errorf("gesture \"%s\" beats itself", name)
+			}
+			beats |= choose
+		}
+		rules.Gestures[i] = GameGesture{
+			Choose: 1 << i,
+			Name:   name,
+			Sign:   strings.TrimSpace(gesture.Sign),
+			Beats:  beats}
+	}
+
+	for i, gesture := range rules.Gestures {
+		wins := 0
+		for j, other := range rules.Gestures {
+			if i == j {
+				continue
+			}
+			a := gesture.Beats&other.Choose != 0
+			b := other.Beats&gesture.Choose != 0
+			if a == b {
+				return nil, fmt.Errorf("gestures \"%s\" and \"%s\" must have exactly one winner",
+					gesture.Name, other.Name)
+			}
+			if a {
+				wins++
+			}
+		}
+		if wins != (cnt-1)/2 {
+			return nil, fmt.Errorf("gesture \"%s\" must beat exactly %d gestures", gesture.Name, (cnt-1)/2)
+		}
+	}
+
+	return rules, nil
+}
+
+/* GameRules impl */
+
+func (rules *GameRules) GetGesture(choose int) *GameGesture {
+	for i := range rules.Gestures {
+		if rules.Gestures[i].Choose == choose {
+			return &rules.Gestures[i]
+		}
+	}
+	return nil
+}
+
+func (rules *GameRules) IsValid(choose int) bool {
+	return rules.GetGesture(choose) != nil
+}
+
+func (rules *GameRules) GetLabel(choose int) string {
+	gesture := rules.GetGesture(choose)
+	if gesture == nil {
+		return ""
+	}
+	if len(gesture.Name) > 0 {
+		return gesture.Sign + " " + gesture.Name
+	}
+	return gesture.Sign
+}
+
+func (rules *GameRules) GetSign(choose int) string {
+	gesture := rules.GetGesture(choose)
+	if gesture == nil {
+		return ""
+	}
+	return gesture.Sign
+}
+
+// Winner returns the gesture that beats every other gesture present
+// in the vote mask or 0 if there is no such gesture (nobody wins)
+func (rules *GameRules) Winner(vote int64) int64 {
+	for _, gesture := range rules.Gestures {
+		choose := int64(gesture.Choose)
+		if vote&choose == 0 {
+			continue
+		}
+		others := vote &^ choose
+		if others != 0 && others&^int64(gesture.Beats) == 0 {
+			return choose
+		}
+	}
+	return 0
+}
+
+const DRAW_NONE = 0
+const DRAW_MAJORITY_LOSES = 1
+const DRAW_MINORITY_WINS = 2
+const DRAW_ELIMINATE_COMMON = 3
+const DRAW_SUDDEN_DEATH = 4
+const DRAW_STRATEGY_CNT = 5
+
+var DRAW_LIMITS = []int{1, 2, 3, 5}
+
+func DrawStrategyToStr(strategy int, locale *LanguageStrings) string {
+	switch strategy {
+	case DRAW_MAJORITY_LOSES:
+		return locale.DrawMajorityLoses
+	case DRAW_MINORITY_WINS:
+		return locale.DrawMinorityWins
+	case DRAW_ELIMINATE_COMMON:
+		return locale.DrawEliminateCommon
+	case DRAW_SUDDEN_DEATH:
+		return locale.DrawSuddenDeath
+	default:
+		return locale.DrawNone
+	}
+}
+
+// ResolveDraw returns the mask of the surviving gestures for the round
+// where nobody wins by the rules or 0 if the draw stays unresolved.
+// counts holds the number of players for every chosen gesture, draws
+// is the number of consecutive draws including the current one
+func ResolveDraw(strategy int, counts map[int64]int, draws int, limit int) int64 {
+	if len(counts) < 2 {
+		// everybody chose the same gesture
+		return 0
+	}
+
+	var vote int64 = 0
+	var max_mask, min_mask int64 = 0, 0
+	max_cnt, min_cnt := 0, -1
+	for choose, cnt := range counts {
+		vote |= choose
+		if cnt > max_cnt {
+			max_cnt = cnt
+			max_mask = choose
+		} else if cnt == max_cnt {
+			max_mask |= choose
+		}
+		if cnt < min_cnt || min_cnt < 0 {
+			min_cnt = cnt
+			min_mask = choose
+		} else if cnt == min_cnt {
+			min_mask |= choose
+		}
+	}
+	single := func(mask int64) bool {
+		return mask != 0 && mask&(mask-1) == 0
+	}
+
+	switch strategy {
+	case DRAW_MAJORITY_LOSES:
+		if single(max_mask) {
+			return vote &^ max_mask
+		}
+	case DRAW_MINORITY_WINS:
+		if single(min_mask) {
+			return min_mask
+		}
+	case DRAW_ELIMINATE_COMMON:
+		return vote &^ max_mask
+	case DRAW_SUDDEN_DEATH:
+		if draws >= limit {
+			// flip the coin between the chosen gestures
+			chooses := make([]int64, 0, len(counts))
+			for choose := range counts {
+				chooses = append(chooses, choose)
+			}
+			slices.Sort(chooses)
+			return chooses[rand.IntN(len(chooses))]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+/*===============================================================*/
+/* The SPS Bot (game rules tests)                                */
+/*                                                               */
+/* Copyright 2024 Ilya Medvedkov                                 */
+/*===============================================================*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cyclicRules returns the balanced tournament of cnt gestures where
+// every gesture beats the next (cnt-1)/2 ones
+func cyclicRules(cnt int) CustomRules {
+	custom := CustomRules{Gestures: make([]CustomGesture, cnt)}
+	for i := range custom.Gestures {
+		gesture := CustomGesture{Name: fmt.Sprintf("g%d", i), Sign: fmt.Sprintf("%d", i)}
+		for j := 1; j <= (cnt-1)/2; j++ {
+			gesture.Beats = append(gesture.Beats, fmt.Sprintf("g%d", (i+j)%cnt))
+		}
+		custom.Gestures[i] = gesture
+	}
+	return custom
+}
+
+func TestCustomRulesCompile(t *testing.T) {
+	modify := func(cnt int, change func(custom *CustomRules)) CustomRules {
+		custom := cyclicRules(cnt)
+		change(&custom)
+		return custom
+	}
+
+	tests := []struct {
+		name   string
+		custom CustomRules
+		ok     bool
+		err    error
+	}{
+		{"three", cyclicRules(3), true, nil},
+		{"five", cyclicRules(5), true, nil},
+		{"max", cyclicRules(MAX_CUSTOM_GESTURES), true, nil},
+		{"two", cyclicRules(2), false, ErrRulesTooFew},
+		{"four", cyclicRules(4), false, ErrRulesEven},
+		{"too many", cyclicRules(MAX_CUSTOM_GESTURES + 2), false, ErrRulesTooMany},
+		{"transitive", modify(3, func(custom *CustomRules) {
+			// g0 beats everyone, g2 beats nobody
+			custom.Gestures[0].Beats = []string{"g1", "g2"}
+			custom.Gestures[1].Beats = []string{"g2"}
+			custom.Gestures[2].Beats = nil
+		}), false, nil},
+		{"unbalanced", modify(5, func(custom *CustomRules) {
+			// every pair still has one winner but g0 beats 3 gestures
+			custom.Gestures[0].Beats = []string{"g1", "g2", "g3"}
+			custom.Gestures[3].Beats = []string{"g4"}
+		}), false, nil},
+		{"mutual", modify(3, func(custom *CustomRules) {
+			custom.Gestures[1].Beats = append(custom.Gestures[1].Beats, "g0")
+		}), false, nil},
+		{"itself", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Beats = []string{"g0"}
+		}), false, nil},
+		{"unknown", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Beats = []string{"g9"}
+		}), false, nil},
+		{"duplicate", modify(3, func(custom *CustomRules) {
+			custom.Gestures[1].Name = " g0 "
+		}), false, nil},
+		{"no name", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Name = "  "
+		}), false, nil},
+		{"no sign", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Sign = ""
+		}), false, nil},
+		{"longest name", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Name = strings.Repeat("я", MAX_GESTURE_NAME_LEN)
+			custom.Gestures[2].Beats = []string{custom.Gestures[0].Name}
+		}), true, nil},
+		{"long name", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Name = strings.Repeat("я", MAX_GESTURE_NAME_LEN+1)
+		}), false, nil},
+		{"longest sign", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Sign = strings.Repeat("\U0001F44A", MAX_GESTURE_SIGN_LEN)
+		}), true, nil},
+		{"long sign", modify(3, func(custom *CustomRules) {
+			custom.Gestures[0].Sign = strings.Repeat("\U0001F44A", MAX_GESTURE_SIGN_LEN+1)
+		}), false, nil},
+	}
+
+	for _, test := range tests {
+		rules, err := test.custom.Compile()
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+				continue
+			}
+			if len(rules.Gestures) != len(test.custom.Gestures) {
+				t.Errorf("%s: got %d gestures, want %d", test.name, len(rules.Gestures), len(test.custom.Gestures))
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: the rules are accepted", test.name)
+		} else if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCustomRulesCompileMasks(t *testing.T) {
+	custom := cyclicRules(5)
+	rules, err := custom.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, gesture := range rules.Gestures {
+		if gesture.Choose != 1<<i {
+			t.Errorf("gesture %d: got choose %d, want %d", i, gesture.Choose, 1<<i)
+		}
+		// g_i beats g_(i+1) and g_(i+2)
+		beats := 1<<((i+1)%5) | 1<<((i+2)%5)
+		if gesture.Beats != beats {
+			t.Errorf("gesture %d: got beats %b, want %b", i, gesture.Beats, beats)
+		}
+	}
+}
+
+func TestGameRulesWinner(t *testing.T) {
+	custom := cyclicRules(5)
+	custom_rules, err := custom.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rules *GameRules
+		vote  int64
+		want  int64
+	}{
+		{"classic stone", &CLASSIC_RULES, CHOOSE_STONE | CHOOSE_SCISSORS, CHOOSE_STONE},
+		{"classic scissors", &CLASSIC_RULES, CHOOSE_SCISSORS | CHOOSE_PAPER, CHOOSE_SCISSORS},
+		{"classic paper", &CLASSIC_RULES, CHOOSE_PAPER | CHOOSE_STONE, CHOOSE_PAPER},
+		{"classic all", &CLASSIC_RULES, CHOOSE_STONE | CHOOSE_SCISSORS | CHOOSE_PAPER, 0},
+		{"classic same", &CLASSIC_RULES, CHOOSE_STONE, 0},
+		{"classic none", &CLASSIC_RULES, 0, 0},
+		{"spock three", &LIZARD_SPOCK_RULES, CHOOSE_STONE | CHOOSE_SCISSORS | CHOOSE_LIZARD, CHOOSE_STONE},
+		{"spock paper", &LIZARD_SPOCK_RULES, CHOOSE_PAPER | CHOOSE_STONE | CHOOSE_SPOCK, CHOOSE_PAPER},
+		{"spock spock", &LIZARD_SPOCK_RULES, CHOOSE_SPOCK | CHOOSE_STONE | CHOOSE_SCISSORS, CHOOSE_SPOCK},
+		{"spock cycle", &LIZARD_SPOCK_RULES, CHOOSE_STONE | CHOOSE_PAPER | CHOOSE_LIZARD, 0},
+		{"spock all", &LIZARD_SPOCK_RULES, 31, 0},
+		{"custom pair", custom_rules, 1<<0 | 1<<2, 1 << 0},
+		{"custom triple", custom_rules, 1<<1 | 1<<2 | 1<<3, 1 << 1},
+		{"custom cycle", custom_rules, 1<<0 | 1<<1 | 1<<3, 0},
+	}
+
+	for _, test := range tests {
+		if got := test.rules.Winner(test.vote); got != test.want {
+			t.Errorf("%s: got %b, want %b", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResolveDraw(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy int
+		counts   map[int64]int
+		draws    int
+		want     int64
+	}{
+		{"none", DRAW_NONE, map[int64]int{1: 2, 2: 1, 4: 1}, 1, 0},
+		{"same gesture", DRAW_MAJORITY_LOSES, map[int64]int{1: 3}, 1, 0},
+		{"majority loses", DRAW_MAJORITY_LOSES, map[int64]int{1: 2, 2: 1, 4: 1}, 1, 2 | 4},
+		{"majority tied", DRAW_MAJORITY_LOSES, map[int64]int{1: 2, 2: 2, 4: 1}, 1, 0},
+		{"minority wins", DRAW_MINORITY_WINS, map[int64]int{1: 2, 2: 2, 4: 1}, 1, 4},
+		{"minority tied", DRAW_MINORITY_WINS, map[int64]int{1: 1, 2: 1, 4: 2}, 1, 0},
+		{"eliminate common", DRAW_ELIMINATE_COMMON, map[int64]int{1: 2, 2: 2, 4: 1}, 1, 4},
+		{"eliminate single", DRAW_ELIMINATE_COMMON, map[int64]int{1: 3, 2: 1, 4: 1}, 1, 2 | 4},
+		{"eliminate equal", DRAW_ELIMINATE_COMMON, map[int64]int{1: 1, 2: 1, 4: 1}, 1, 0},
+		{"sudden death waits", DRAW_SUDDEN_DEATH, map[int64]int{1: 1, 2: 1, 4: 1}, 2, 0},
+	}
+
+	for _, test := range tests {
+		if got := ResolveDraw(test.strategy, test.counts, test.draws, 3); got != test.want {
+			t.Errorf("%s: got %b, want %b", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResolveDrawSuddenDeath(t *testing.T) {
+	counts := map[int64]int{1: 1, 2: 1, 4: 1}
+	seen := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		got := ResolveDraw(DRAW_SUDDEN_DEATH, counts, 3, 3)
+		if _, ok := counts[got]; !ok {
+			t.Fatalf("got %b, want one of the chosen gestures", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("the coin always falls on %v", seen)
+	}
+}
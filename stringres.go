@@ -7,50 +7,217 @@
 package main
 
 type LanguageStrings struct {
-	IETFCode           string
-	Greetings          string
-	AlreadyAuthorized  string
-	NotAuthorized      string
-	CommandStart       string
-	CommandNewRoom     string
-	CommandJoinRoom    string
-	CommandCloseRoom   string
-	CommandSett        string
-	CommandExitRoom    string
-	CommandRestartRoom string
-	CommandGetStat     string
-	MemberDisconnected string
-	MemberConnected    string
-	ChooseSPS          string
-	RResYouWin         string
-	RResYouLoose       string
-	RResWinNobody      string
-	RResRoundFinished  string
-	PSTPlaying         string
-	PSTWatching        string
-	PSTUnknown         string
-	GameFinished       string
-	Congratulations    string
-	UserStat           string
-	EvtYourTurn        string
-	EvtWaitForTurn     string
-	EvtRoomFinished    string
-	EvtRoomClosed      string
-	RoomNotReady       string
-	RoomAlreadyClosed  string
-	NoRoomDetected     string
-	NoParams           string
-	NoSuchRoom         string
-	NotValidRoom       string
-	EmptyCallback      string
-	RoomCreated        string
-	JoinRoomInvite     string
-	SetNewRoomName     string
-	SetExistRoomName   string
-	UnsupportedMsg     string
-	RoomClosed         string
-	NoActiveRooms      string
-	ErrorDetected      string
+	IETFCode                string
+	Greetings               string
+	AlreadyAuthorized       string
+	NotAuthorized           string
+	CommandStart            string
+	CommandNewRoom          string
+	CommandJoinRoom         string
+	CommandCloseRoom        string
+	CommandSett             string
+	CommandMyRooms          string
+	CommandFindGame         string
+	CommandTop              string
+	CommandHistory          string
+	CommandExport           string
+	CommandExitRoom         string
+	CommandRestartRoom      string
+	CommandGetStat          string
+	MemberDisconnected      string
+	MemberConnected         string
+	ChooseSPS               string
+	RResYouWin              string
+	RResYouLoose            string
+	RResWinNobody           string
+	RResRoundFinished       string
+	PSTPlaying              string
+	PSTWatching             string
+	PSTUnknown              string
+	GameFinished            string
+	Congratulations         string
+	UserStat                string
+	EvtYourTurn             string
+	EvtWaitForTurn          string
+	EvtRoomFinished         string
+	EvtRoomIdle             string
+	EvtRoomClosed           string
+	RoomNotReady            string
+	RoomAlreadyClosed       string
+	NoRoomDetected          string
+	NoParams                string
+	NoSuchRoom              string
+	NotValidRoom            string
+	EmptyCallback           string
+	RoomCreated             string
+	JoinRoomInvite          string
+	SpectateRoomInvite      string
+	SetNewRoomName          string
+	SetExistRoomName        string
+	UnsupportedMsg          string
+	RoomClosed              string
+	NoActiveRooms           string
+	NotRoomOwner            string
+	RoomSettings            string
+	SettRules               string
+	RulesClassic            string
+	RulesLizardSpock        string
+	RulesCustom             string
+	SettCustomRules         string
+	SetCustomRules          string
+	RulesNotValid           string
+	SettTimeout             string
+	SettPolicy              string
+	TimeoutNone             string
+	TimeoutSeconds          string
+	TimeoutEliminate        string
+	TimeoutRandom           string
+	TimeoutSkip             string
+	EvtTurnDeadline         string
+	EvtTurnWarning          string
+	SettMatch               string
+	MatchSingle             string
+	MatchFirstTo            string
+	MatchSession            string
+	EvtMatchWon             string
+	EvtMatchNoWinner        string
+	SettDraw                string
+	SettDrawLimit           string
+	DrawNone                string
+	DrawMajorityLoses       string
+	DrawMinorityWins        string
+	DrawEliminateCommon     string
+	DrawSuddenDeath         string
+	DrawResolved            string
+	SettMode                string
+	SettRounds              string
+	ModeElimination         string
+	ModeScoring             string
+	RResScoring             string
+	RResYouLooseLife        string
+	SettLives               string
+	SettAddAI               string
+	AIRandom                string
+	AIFrequency             string
+	AIMarkov                string
+	AIAdded                 string
+	SettCommitReveal        string
+	ValueOn                 string
+	ValueOff                string
+	EvtCommitment           string
+	EvtChoiceCommitted      string
+	RResReveal              string
+	RResSpectators          string
+	CommandJoinQueue        string
+	JoinQueue               string
+	JoinQueueEmpty          string
+	QueueApprove            string
+	QueueDrop               string
+	QueueRefresh            string
+	EvtMemberQueued         string
+	EvtJoinQueued           string
+	EvtQueueSessionFinished string
+	EvtJoinDropped          string
+	SettOwnerLeave          string
+	OwnerLeaveClose         string
+	OwnerLeaveTransfer      string
+	TransferPick            string
+	TransferTo              string
+	TransferNobody          string
+	OwnerHasRoom            string
+	EvtOwnerChanged         string
+	SettKick                string
+	SettBans                string
+	KickPick                string
+	BanPick                 string
+	KickMember              string
+	BanMember               string
+	UnbanMember             string
+	BannedList              string
+	NoMembers               string
+	YouAreBanned            string
+	EvtKicked               string
+	EvtBanned               string
+	SettPassword            string
+	SettNoPassword          string
+	SetPassword             string
+	EnterPassword           string
+	WrongPassword           string
+	TooManyAttempts         string
+	SettMinPlayers          string
+	SettMaxPlayers          string
+	SettAutoStart           string
+	PlayersUnlimited        string
+	RoomFull                string
+	NotEnoughPlayers        string
+	EvtAutoStart            string
+	EvtAutoStartFailed      string
+	SettInviteTTL           string
+	SettInviteUses          string
+	SettNewInvite           string
+	TTLHours                string
+	TTLDays                 string
+	InviteExpired           string
+	InviteRevoked           string
+	InviteLinksRevoked      string
+	CallbackInvalid         string
+	CallbackStale           string
+	ChoiceCommitted         string
+	MyRooms                 string
+	MyRoomsEmpty            string
+	MyRoomsItem             string
+	MyRoomsEnter            string
+	MyRoomsInvite           string
+	MyRoomsRename           string
+	MyRoomsDelete           string
+	RoomStateWaiting        string
+	RoomStateStarted        string
+	RoomStateFinished       string
+	SetRoomName             string
+	RoomRenamed             string
+	RoomInProgress          string
+	RoomExists              string
+	DeleteRoomConfirm       string
+	Cancel                  string
+	LobbyChoosePlayers      string
+	LobbyWaiting            string
+	LobbyCancelled          string
+	EvtMatchFound           string
+	EvtLobbyExpired         string
+	EvtLobbyFinished        string
+	Top                     string
+	TopMinGames             string
+	TopEmpty                string
+	TopItem                 string
+	TopWins                 string
+	TopWinRate              string
+	TopRating               string
+	TopScopeGlobal          string
+	TopScopeChat            string
+	TopScopeRoom            string
+	TopByWins               string
+	TopByWinRate            string
+	TopByRating             string
+	History                 string
+	HistoryEmpty            string
+	HistoryItem             string
+	HistoryWon              string
+	HistoryLost             string
+	HistoryNotFinished      string
+	HistoryGame             string
+	HistoryRound            string
+	HistoryRoundTitle       string
+	HistoryMoreRounds       string
+	HistoryNobody           string
+	HistorySkipped          string
+	HistoryEliminated       string
+	HistoryBack             string
+	ExportChooseFormat      string
+	ExportSending           string
+	ExportCaption           string
+	VerifyOk                string
+	VerifyFailed            string
+	ErrorDetected           string
 }
 
 var EN_STRINGS = LanguageStrings{
@@ -65,6 +232,11 @@ var EN_STRINGS = LanguageStrings{
 	CommandJoinRoom:    "Join to the room",
 	CommandCloseRoom:   "Close the room and start game",
 	CommandSett:        "View all settings options",
+	CommandMyRooms:     "Manage your rooms",
+	CommandFindGame:    "Find a game with other players",
+	CommandTop:         "Leaderboards",
+	CommandHistory:     "Your recent games",
+	CommandExport:      "Export your game history",
 	CommandExitRoom:    "Exit from current room",
 	CommandRestartRoom: "Restart room \"%s\"",
 	CommandGetStat:     "Get users's game statistics",
@@ -84,27 +256,194 @@ var EN_STRINGS = LanguageStrings{
 
 	GameFinished:    "Game in your room is finished",
 	Congratulations: "\U0001f44f",
-	UserStat:        "The game bot @%s \U0000270A\U0000270C\U0000270B introducing\nThe game statistic for @%s\n\n\U0001F973 %d\n\U0001F614 %d",
+	UserStat:        "The game bot @%s \U0000270A\U0000270C\U0000270B introducing\nThe game statistic for @%s\n\n\U0001F973 %d\n\U0001F614 %d\n\U0001F3C6 %d\n\U0001F4C8 %.0f \u00B1%.0f",
 
 	EvtYourTurn:     "Now is your turn <b>%s</b>! Make your choose",
 	EvtWaitForTurn:  "Now is the round %d in progress. Waiting",
 	EvtRoomFinished: "Room @%s.\"%s\" is finished by owner",
+	EvtRoomIdle:     "Room @%s.\"%s\" is closed due to inactivity",
 	EvtRoomClosed:   "Room @%s.\"%s\" is closed. The game is started",
 
-	RoomCreated:       "Room %s created",
-	JoinRoomInvite:    "You was invited to play \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Join</a> to room <b>%s</b>",
-	RoomNotReady:      "Room is not ready",
-	RoomAlreadyClosed: "Room already closed",
-	NoRoomDetected:    "No room detected for the user. Try to create a new one",
-	NoParams:          "Parameters not received",
-	NoSuchRoom:        "Room \"%s\" is not found",
-	NotValidRoom:      "Requested room is not found",
-	SetNewRoomName:    "%s\nSet the new room name:",
-	SetExistRoomName:  "Set the exist room name:",
-	EmptyCallback:     "Empty callback",
-	UnsupportedMsg:    "Unsupported message format",
-	RoomClosed:        "The room is closed. Try to connect later",
-	NoActiveRooms:     "No active rooms",
+	RoomCreated:        "Room %s created",
+	JoinRoomInvite:     "You was invited to play \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Join</a> to room <b>%s</b>",
+	SpectateRoomInvite: "You was invited to watch \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Watch</a> the room <b>%s</b>",
+	RoomNotReady:       "Room is not ready",
+	RoomAlreadyClosed:  "Room already closed",
+	NoRoomDetected:     "No room detected for the user. Try to create a new one",
+	NoParams:           "Parameters not received",
+	NoSuchRoom:         "Room \"%s\" is not found",
+	NotValidRoom:       "Requested room is not found",
+	SetNewRoomName:     "%s\nSet the new room name:",
+	SetExistRoomName:   "Set the exist room name:",
+	EmptyCallback:      "Empty callback",
+	UnsupportedMsg:     "Unsupported message format",
+	RoomClosed:         "The room is closed. Try to connect later",
+	NoActiveRooms:      "No active rooms",
+	NotRoomOwner:       "Only the room owner can do this",
+	RoomSettings:       "Settings of the room <b>%s</b>",
+	SettRules:          "Rules: %s",
+	RulesClassic:       "\U0000270A\U0000270C\U0000270B classic",
+	RulesLizardSpock:   "\U0000270A\U0000270C\U0000270B\U0001F98E\U0001F596 lizard-Spock",
+	RulesCustom:        "\U0001F3A8 custom",
+	SettCustomRules:    "Define custom rules",
+	SetCustomRules: "%s_%s\nSend the custom rules as JSON. " +
+		"Every gesture must beat exactly half of the others, for example:\n" +
+		"<code>{\"gestures\": [" +
+		"{\"name\": \"fire\", \"sign\": \"\U0001F525\", \"beats\": [\"plant\"]}, " +
+		"{\"name\": \"water\", \"sign\": \"\U0001F4A7\", \"beats\": [\"fire\"]}, " +
+		"{\"name\": \"plant\", \"sign\": \"\U0001F331\", \"beats\": [\"water\"]}]}</code>",
+	RulesNotValid:           "The rules are not valid: %s",
+	SettTimeout:             "\U000023F1 Turn: %s",
+	SettPolicy:              "On timeout: %s",
+	TimeoutNone:             "unlimited",
+	TimeoutSeconds:          "%d s",
+	TimeoutEliminate:        "eliminate",
+	TimeoutRandom:           "random move",
+	TimeoutSkip:             "skip",
+	EvtTurnDeadline:         "You have %d seconds. On timeout: %s",
+	EvtTurnWarning:          "\U000023F3 Hurry up! Only %d seconds left to make your choose",
+	SettMatch:               "\U0001F3C6 Match: %s",
+	MatchSingle:             "single game",
+	MatchFirstTo:            "first to %d wins",
+	MatchSession:            "Game %d of the match (first to %d wins)",
+	EvtMatchWon:             "\U0001F3C6 <b>%s</b> wins the match with %d game wins in the room \"%s\"",
+	EvtMatchNoWinner:        "The match in the room \"%s\" is finished without a winner",
+	SettDraw:                "\U0001F91D On draw: %s",
+	SettDrawLimit:           "after %d draws",
+	DrawNone:                "replay",
+	DrawMajorityLoses:       "majority gesture loses",
+	DrawMinorityWins:        "minority gesture wins",
+	DrawEliminateCommon:     "most common gestures are eliminated",
+	DrawSuddenDeath:         "sudden death coin flip",
+	DrawResolved:            "Draw resolved (%s). Survived: %s",
+	SettMode:                "\U0001F3B2 Mode: %s",
+	SettRounds:              "%d rounds",
+	ModeElimination:         "elimination",
+	ModeScoring:             "round-robin scoring",
+	RResScoring:             "Round %d of %d finished. You earned %d points",
+	RResYouLooseLife:        "You loose this round and one life. Lives left: %d",
+	SettLives:               "\U00002764 Lives: %d",
+	SettAddAI:               "\U0001F916+ %s",
+	AIRandom:                "random",
+	AIFrequency:             "frequency",
+	AIMarkov:                "Markov",
+	AIAdded:                 "AI player @%s (%s) is added to the room <b>%s</b>",
+	SettCommitReveal:        "\U0001F512 Commit-reveal: %s",
+	ValueOn:                 "on",
+	ValueOff:                "off",
+	EvtCommitment:           "\U0001F512 <b>%s</b>: <code>%s</code>",
+	EvtChoiceCommitted:      "\U0001F512 Your choose %s is committed: <code>%s</code>",
+	RResReveal:              "\U0001F511 Revealed (send the line to verify):",
+	RResSpectators:          "\U0001F441 Spectators: %d",
+	CommandJoinQueue:        "\U0001F465 Join queue (%d)",
+	JoinQueue:               "\U0001F465 Join queue of the room <b>%s</b>:",
+	JoinQueueEmpty:          "The queue is empty",
+	QueueApprove:            "\U00002705 @%s",
+	QueueDrop:               "\U0000274C @%s",
+	QueueRefresh:            "\U0001F504 Refresh",
+	EvtMemberQueued:         "@%s is waiting to join the game",
+	EvtJoinQueued:           "The game in the room @%s.\"%s\" is in progress. You are in the queue and will join when the owner restarts the game",
+	EvtQueueSessionFinished: "The game in the room @%s.\"%s\" is finished. You will join it when the owner restarts the game",
+	EvtJoinDropped:          "The owner of the room @%s.\"%s\" declined your request to join",
+	SettOwnerLeave:          "\U0001F451 Owner leaves: %s",
+	OwnerLeaveClose:         "close",
+	OwnerLeaveTransfer:      "hand over",
+	TransferPick:            "\U0001F451 Choose the new owner of the room <b>%s</b>:",
+	TransferTo:              "\U0001F451 @%s",
+	TransferNobody:          "There is nobody to hand the room over",
+	OwnerHasRoom:            "The member already has the room with the same name",
+	EvtOwnerChanged:         "\U0001F451 @%s is the new owner of the room \"%s\"",
+	SettKick:                "\U0001F6AA Kick",
+	SettBans:                "\U000026D4 Bans",
+	KickPick:                "\U0001F6AA Choose the member to kick from the room <b>%s</b>:",
+	BanPick:                 "\U000026D4 Choose the member to ban in the room <b>%s</b>:",
+	KickMember:              "\U0001F6AA @%s",
+	BanMember:               "\U000026D4 @%s",
+	UnbanMember:             "\U00002705 Unban @%s",
+	BannedList:              "Banned:",
+	NoMembers:               "There are no members",
+	YouAreBanned:            "You are banned in this room",
+	EvtKicked:               "You were kicked from the room @%s.\"%s\"",
+	EvtBanned:               "You were banned in the room @%s.\"%s\"",
+	SettPassword:            "\U0001F511 Password: %s",
+	SettNoPassword:          "\U0000274C Remove password",
+	SetPassword:             "%s_%s\nSend the password for the room. The message with the password will be deleted",
+	EnterPassword:           "%s_%s\n\U0001F511 The room is protected with the password. Send the password to join",
+	WrongPassword:           "Wrong password. Attempts left: %d",
+	TooManyAttempts:         "Too many wrong passwords. Try again later",
+	SettMinPlayers:          "\U0001F465 Min: %d",
+	SettMaxPlayers:          "Max: %s",
+	SettAutoStart:           "\U0001F680 Auto-start: %s",
+	PlayersUnlimited:        "\U0000267E",
+	RoomFull:                "The room is full",
+	NotEnoughPlayers:        "At least %d players are needed to start the game",
+	EvtAutoStart:            "The room @%s.\"%s\" is ready. The game starts in %d seconds",
+	EvtAutoStartFailed:      "The auto-start of the room @%s.\"%s\" is canceled: %s",
+	SettInviteTTL:           "\U000023F3 Link: %s",
+	SettInviteUses:          "Uses: %s",
+	SettNewInvite:           "\U0001F517 New links",
+	TTLHours:                "%dh",
+	TTLDays:                 "%dd",
+	InviteExpired:           "This invite link has expired. Ask the room owner for a new one",
+	InviteRevoked:           "This invite link was revoked by the room owner",
+	InviteLinksRevoked:      "The invite links to the room <b>%s</b> are revoked",
+	CallbackInvalid:         "This button is no longer valid",
+	CallbackStale:           "This round is already over",
+	ChoiceCommitted:         "Your choice is already committed and can not be changed",
+	MyRooms:                 "<b>Your rooms</b>",
+	MyRoomsEmpty:            "You have no rooms yet",
+	MyRoomsItem:             "%d. <b>%s</b> - %s, members: %d, last used: %s",
+	MyRoomsEnter:            "\U000027A1 %d",
+	MyRoomsInvite:           "\U0001F517",
+	MyRoomsRename:           "\U0000270F",
+	MyRoomsDelete:           "\U0001F5D1",
+	RoomStateWaiting:        "waiting for players",
+	RoomStateStarted:        "game in progress",
+	RoomStateFinished:       "waiting for restart",
+	SetRoomName:             "%s_%s\nSend the new name for the room <b>%s</b>",
+	RoomRenamed:             "The room is renamed to <b>%s</b>",
+	RoomInProgress:          "The room can not be changed while the game is in progress",
+	RoomExists:              "You already have the room with this name",
+	DeleteRoomConfirm:       "Delete the room <b>%s</b>? All members will be removed",
+	Cancel:                  "Cancel",
+	LobbyChoosePlayers:      "Choose the number of players",
+	LobbyWaiting:            "Searching for the game of %d players...",
+	LobbyCancelled:          "The search is cancelled",
+	EvtMatchFound:           "The game is found! You play in the room <b>%s</b>",
+	EvtLobbyExpired:         "Nobody was found to play with. Try %s again later",
+	EvtLobbyFinished:        "The game is over. Use %s to play again",
+	Top:                     "\U0001F3C6 <b>Leaderboard</b> (%s, %s)",
+	TopMinGames:             "Only the players with %d games or more are ranked",
+	TopEmpty:                "Nobody is here yet",
+	TopItem:                 "%d. <b>%s</b> \U00002014 %s",
+	TopWins:                 "%d wins of %d",
+	TopWinRate:              "%d%% of %d games",
+	TopRating:               "%.0f \u00B1%.0f",
+	TopScopeGlobal:          "Global",
+	TopScopeChat:            "Chat",
+	TopScopeRoom:            "Players of this room",
+	TopByWins:               "Wins",
+	TopByWinRate:            "Win rate",
+	TopByRating:             "Rating",
+	History:                 "<b>Your recent games</b>",
+	HistoryEmpty:            "You have not played yet",
+	HistoryItem:             "%d. <b>%s</b> %s\n%s, rounds: %d, players: %d",
+	HistoryWon:              "\U0001F3C6 won",
+	HistoryLost:             "lost",
+	HistoryNotFinished:      "not finished",
+	HistoryGame:             "<b>The game in the room %s</b>\nStarted at %s, %s",
+	HistoryRound:            "Round %d: your move %s, the winner %s",
+	HistoryRoundTitle:       "<b>Round %d</b> of the game in the room %s",
+	HistoryMoreRounds:       "...and %d more rounds",
+	HistoryNobody:           "nobody",
+	HistorySkipped:          "skipped",
+	HistoryEliminated:       "\U0000274C eliminated",
+	HistoryBack:             "Back",
+	ExportChooseFormat:      "Choose the format of the file with your game history",
+	ExportSending:           "Preparing the file...",
+	ExportCaption:           "Your game history, statistics and gestures",
+	VerifyOk:                "\U00002705 The commitment matches the revealed choice",
+	VerifyFailed:            "\U0000274C The commitment does not match the revealed choice (expected <code>%s</code>)",
 	ErrorDetected: "<pre>Error detected</pre>\n" +
 		"<pre>%s</pre>\nTry to use the bot service properly",
 }
@@ -121,6 +460,11 @@ var RU_STRINGS = LanguageStrings{
 	CommandJoinRoom:    "Присоединиться к комнате",
 	CommandCloseRoom:   "Закрыть комнату и начать игру",
 	CommandSett:        "Показать все настройки",
+	CommandMyRooms:     "Управление вашими комнатами",
+	CommandFindGame:    "Найти игру с другими игроками",
+	CommandTop:         "Таблицы лидеров",
+	CommandHistory:     "Ваши последние игры",
+	CommandExport:      "Выгрузить историю игр",
 	CommandExitRoom:    "Выйти из текущей комнаты",
 	CommandRestartRoom: "Перезапуск комнаты \"%s\"",
 	CommandGetStat:     "Показать игровую статистику",
@@ -140,27 +484,194 @@ var RU_STRINGS = LanguageStrings{
 
 	GameFinished:    "Игра в вашей комнате завершена",
 	Congratulations: "\U0001f44f",
-	UserStat:        "Бот @%s для игры в \U0000270A\U0000270C\U0000270B представляет\nИгровую статистику для @%s\n\n\U0001F973 %d\n\U0001F614 %d",
+	UserStat:        "Бот @%s для игры в \U0000270A\U0000270C\U0000270B представляет\nИгровую статистику для @%s\n\n\U0001F973 %d\n\U0001F614 %d\n\U0001F3C6 %d\n\U0001F4C8 %.0f \u00B1%.0f",
 
 	EvtYourTurn:     "Сейчас ваш ход <b>%s</b>! Сделайте выбор",
 	EvtWaitForTurn:  "Раунд %d в прогрессе. Ожидание",
 	EvtRoomFinished: "Комната @%s.\"%s\" закрыта, игра завершена пользователем",
+	EvtRoomIdle:     "Комната @%s.\"%s\" закрыта из-за неактивности",
 	EvtRoomClosed:   "Комната @%s.\"%s\" закрыта. Игра начата",
 
-	RoomCreated:       "Комната %s создана",
-	JoinRoomInvite:    "Вас пригласили для игры в \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Присоединитесь</a> к комнате <b>%s</b>",
-	RoomNotReady:      "Комната не готова",
-	RoomAlreadyClosed: "Комната уже закрыта",
-	NoRoomDetected:    "Нет открытых комнат для вас. Попробуйте создать новую",
-	NoParams:          "Параметры не переданы",
-	NoSuchRoom:        "Комната \"%s\" не найдена",
-	NotValidRoom:      "Запрашиваемая комната не найдена",
-	SetNewRoomName:    "%s\nЗадайте имя комнаты:",
-	SetExistRoomName:  "Задайте существующее имя комнаты:",
-	EmptyCallback:     "Пустой возврат",
-	UnsupportedMsg:    "Формат сообщения не поддерживается",
-	NoActiveRooms:     "Нет активных комнат",
-	RoomClosed:        "Комната закрыта сейчас. Попробуйте присоединиться позже",
+	RoomCreated:        "Комната %s создана",
+	JoinRoomInvite:     "Вас пригласили для игры в \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Присоединитесь</a> к комнате <b>%s</b>",
+	SpectateRoomInvite: "Вас пригласили посмотреть игру в \U0000270A\U0000270C\U0000270B\n <a href=\"https://t.me/%s?start=%s_%s\">Наблюдайте</a> за комнатой <b>%s</b>",
+	RoomNotReady:       "Комната не готова",
+	RoomAlreadyClosed:  "Комната уже закрыта",
+	NoRoomDetected:     "Нет открытых комнат для вас. Попробуйте создать новую",
+	NoParams:           "Параметры не переданы",
+	NoSuchRoom:         "Комната \"%s\" не найдена",
+	NotValidRoom:       "Запрашиваемая комната не найдена",
+	SetNewRoomName:     "%s\nЗадайте имя комнаты:",
+	SetExistRoomName:   "Задайте существующее имя комнаты:",
+	EmptyCallback:      "Пустой возврат",
+	UnsupportedMsg:     "Формат сообщения не поддерживается",
+	NoActiveRooms:      "Нет активных комнат",
+	RoomClosed:         "Комната закрыта сейчас. Попробуйте присоединиться позже",
+	NotRoomOwner:       "Это может сделать только владелец комнаты",
+	RoomSettings:       "Настройки комнаты <b>%s</b>",
+	SettRules:          "Правила: %s",
+	RulesClassic:       "\U0000270A\U0000270C\U0000270B классика",
+	RulesLizardSpock:   "\U0000270A\U0000270C\U0000270B\U0001F98E\U0001F596 ящерица-Спок",
+	RulesCustom:        "\U0001F3A8 свои",
+	SettCustomRules:    "Задать свои правила",
+	SetCustomRules: "%s_%s\nОтправьте свои правила в формате JSON. " +
+		"Каждый жест должен побеждать ровно половину остальных, например:\n" +
+		"<code>{\"gestures\": [" +
+		"{\"name\": \"огонь\", \"sign\": \"\U0001F525\", \"beats\": [\"трава\"]}, " +
+		"{\"name\": \"вода\", \"sign\": \"\U0001F4A7\", \"beats\": [\"огонь\"]}, " +
+		"{\"name\": \"трава\", \"sign\": \"\U0001F331\", \"beats\": [\"вода\"]}]}</code>",
+	RulesNotValid:           "Правила некорректны: %s",
+	SettTimeout:             "\U000023F1 Ход: %s",
+	SettPolicy:              "По таймауту: %s",
+	TimeoutNone:             "без ограничений",
+	TimeoutSeconds:          "%d с",
+	TimeoutEliminate:        "выбывание",
+	TimeoutRandom:           "случайный ход",
+	TimeoutSkip:             "пропуск",
+	EvtTurnDeadline:         "У вас %d секунд. По таймауту: %s",
+	EvtTurnWarning:          "\U000023F3 Поторопитесь! Осталось %d секунд, чтобы сделать выбор",
+	SettMatch:               "\U0001F3C6 Матч: %s",
+	MatchSingle:             "одна игра",
+	MatchFirstTo:            "до %d побед",
+	MatchSession:            "Игра %d матча (до %d побед)",
+	EvtMatchWon:             "\U0001F3C6 <b>%s</b> побеждает в матче, выиграв игр: %d, в комнате \"%s\"",
+	EvtMatchNoWinner:        "Матч в комнате \"%s\" завершен без победителя",
+	SettDraw:                "\U0001F91D При ничьей: %s",
+	SettDrawLimit:           "после %d ничьих",
+	DrawNone:                "переиграть",
+	DrawMajorityLoses:       "жест большинства проигрывает",
+	DrawMinorityWins:        "жест меньшинства побеждает",
+	DrawEliminateCommon:     "самые частые жесты выбывают",
+	DrawSuddenDeath:         "внезапная смерть, жребий",
+	DrawResolved:            "Ничья разрешена (%s). Остались: %s",
+	SettMode:                "\U0001F3B2 Режим: %s",
+	SettRounds:              "раундов: %d",
+	ModeElimination:         "на выбывание",
+	ModeScoring:             "круговой на очки",
+	RResScoring:             "Раунд %d из %d завершен. Вы заработали очков: %d",
+	RResYouLooseLife:        "Вы проиграли этот раунд и одну жизнь. Осталось жизней: %d",
+	SettLives:               "\U00002764 Жизни: %d",
+	SettAddAI:               "\U0001F916+ %s",
+	AIRandom:                "случайный",
+	AIFrequency:             "частотный",
+	AIMarkov:                "Марков",
+	AIAdded:                 "ИИ-игрок @%s (%s) добавлен в комнату <b>%s</b>",
+	SettCommitReveal:        "\U0001F512 Обязательства: %s",
+	ValueOn:                 "вкл",
+	ValueOff:                "выкл",
+	EvtCommitment:           "\U0001F512 <b>%s</b>: <code>%s</code>",
+	EvtChoiceCommitted:      "\U0001F512 Ваш выбор %s зафиксирован: <code>%s</code>",
+	RResReveal:              "\U0001F511 Раскрытие (отправьте строку для проверки):",
+	RResSpectators:          "\U0001F441 Зрителей: %d",
+	CommandJoinQueue:        "\U0001F465 Очередь (%d)",
+	JoinQueue:               "\U0001F465 Очередь на вход в комнату <b>%s</b>:",
+	JoinQueueEmpty:          "Очередь пуста",
+	QueueApprove:            "\U00002705 @%s",
+	QueueDrop:               "\U0000274C @%s",
+	QueueRefresh:            "\U0001F504 Обновить",
+	EvtMemberQueued:         "@%s ожидает возможности присоединиться к игре",
+	EvtJoinQueued:           "В комнате @%s.\"%s\" идет игра. Вы в очереди и присоединитесь, когда владелец перезапустит игру",
+	EvtQueueSessionFinished: "Игра в комнате @%s.\"%s\" завершена. Вы присоединитесь, когда владелец перезапустит игру",
+	EvtJoinDropped:          "Владелец комнаты @%s.\"%s\" отклонил ваш запрос на вход",
+	SettOwnerLeave:          "\U0001F451 Уход владельца: %s",
+	OwnerLeaveClose:         "закрыть",
+	OwnerLeaveTransfer:      "передать",
+	TransferPick:            "\U0001F451 Выберите нового владельца комнаты <b>%s</b>:",
+	TransferTo:              "\U0001F451 @%s",
+	TransferNobody:          "Некому передать комнату",
+	OwnerHasRoom:            "У участника уже есть комната с таким же названием",
+	EvtOwnerChanged:         "\U0001F451 @%s теперь владелец комнаты \"%s\"",
+	SettKick:                "\U0001F6AA Исключить",
+	SettBans:                "\U000026D4 Блокировки",
+	KickPick:                "\U0001F6AA Выберите участника, которого нужно исключить из комнаты <b>%s</b>:",
+	BanPick:                 "\U000026D4 Выберите участника, которого нужно заблокировать в комнате <b>%s</b>:",
+	KickMember:              "\U0001F6AA @%s",
+	BanMember:               "\U000026D4 @%s",
+	UnbanMember:             "\U00002705 Разблокировать @%s",
+	BannedList:              "Заблокированы:",
+	NoMembers:               "Нет участников",
+	YouAreBanned:            "Вы заблокированы в этой комнате",
+	EvtKicked:               "Вас исключили из комнаты @%s.\"%s\"",
+	EvtBanned:               "Вас заблокировали в комнате @%s.\"%s\"",
+	SettPassword:            "\U0001F511 Пароль: %s",
+	SettNoPassword:          "\U0000274C Удалить пароль",
+	SetPassword:             "%s_%s\nОтправьте пароль для комнаты. Сообщение с паролем будет удалено",
+	EnterPassword:           "%s_%s\n\U0001F511 Комната защищена паролем. Отправьте пароль, чтобы присоединиться",
+	WrongPassword:           "Неверный пароль. Осталось попыток: %d",
+	TooManyAttempts:         "Слишком много неверных паролей. Попробуйте позже",
+	SettMinPlayers:          "\U0001F465 Мин: %d",
+	SettMaxPlayers:          "Макс: %s",
+	SettAutoStart:           "\U0001F680 Автостарт: %s",
+	PlayersUnlimited:        "\U0000267E",
+	RoomFull:                "Комната заполнена",
+	NotEnoughPlayers:        "Для начала игры нужно не менее %d игроков",
+	EvtAutoStart:            "Комната @%s.\"%s\" готова. Игра начнется через %d секунд",
+	EvtAutoStartFailed:      "Автостарт комнаты @%s.\"%s\" отменен: %s",
+	SettInviteTTL:           "\U000023F3 Ссылка: %s",
+	SettInviteUses:          "Входов: %s",
+	SettNewInvite:           "\U0001F517 Новые ссылки",
+	TTLHours:                "%dч",
+	TTLDays:                 "%dд",
+	InviteExpired:           "Срок действия приглашения истек. Попросите у владельца комнаты новое",
+	InviteRevoked:           "Приглашение было отозвано владельцем комнаты",
+	InviteLinksRevoked:      "Приглашения в комнату <b>%s</b> отозваны",
+	CallbackInvalid:         "Эта кнопка больше не действует",
+	CallbackStale:           "Этот раунд уже завершен",
+	ChoiceCommitted:         "Ваш выбор уже зафиксирован и не может быть изменен",
+	MyRooms:                 "<b>Ваши комнаты</b>",
+	MyRoomsEmpty:            "У вас пока нет комнат",
+	MyRoomsItem:             "%d. <b>%s</b> - %s, участников: %d, использована: %s",
+	MyRoomsEnter:            "\U000027A1 %d",
+	MyRoomsInvite:           "\U0001F517",
+	MyRoomsRename:           "\U0000270F",
+	MyRoomsDelete:           "\U0001F5D1",
+	RoomStateWaiting:        "ожидание игроков",
+	RoomStateStarted:        "идет игра",
+	RoomStateFinished:       "ожидание перезапуска",
+	SetRoomName:             "%s_%s\nОтправьте новое название для комнаты <b>%s</b>",
+	RoomRenamed:             "Комната переименована в <b>%s</b>",
+	RoomInProgress:          "Комнату нельзя изменить во время игры",
+	RoomExists:              "У вас уже есть комната с таким названием",
+	DeleteRoomConfirm:       "Удалить комнату <b>%s</b>? Все участники будут удалены",
+	Cancel:                  "Отмена",
+	LobbyChoosePlayers:      "Выберите количество игроков",
+	LobbyWaiting:            "Поиск игры на %d игроков...",
+	LobbyCancelled:          "Поиск отменен",
+	EvtMatchFound:           "Игра найдена! Вы играете в комнате <b>%s</b>",
+	EvtLobbyExpired:         "Не удалось найти соперников. Попробуйте %s позже",
+	EvtLobbyFinished:        "Игра окончена. Используйте %s, чтобы сыграть снова",
+	Top:                     "\U0001F3C6 <b>Таблица лидеров</b> (%s, %s)",
+	TopMinGames:             "Учитываются только игроки, сыгравшие %d игр или больше",
+	TopEmpty:                "Здесь пока никого нет",
+	TopItem:                 "%d. <b>%s</b> \U00002014 %s",
+	TopWins:                 "%d побед из %d",
+	TopWinRate:              "%d%% из %d игр",
+	TopRating:               "%.0f \u00B1%.0f",
+	TopScopeGlobal:          "Все",
+	TopScopeChat:            "Чат",
+	TopScopeRoom:            "Игроки этой комнаты",
+	TopByWins:               "Победы",
+	TopByWinRate:            "Процент побед",
+	TopByRating:             "Рейтинг",
+	History:                 "<b>Ваши последние игры</b>",
+	HistoryEmpty:            "Вы еще не играли",
+	HistoryItem:             "%d. <b>%s</b> %s\n%s, раундов: %d, игроков: %d",
+	HistoryWon:              "\U0001F3C6 победа",
+	HistoryLost:             "поражение",
+	HistoryNotFinished:      "не завершена",
+	HistoryGame:             "<b>Игра в комнате %s</b>\nНачата %s, %s",
+	HistoryRound:            "Раунд %d: ваш ход %s, победитель %s",
+	HistoryRoundTitle:       "<b>Раунд %d</b> игры в комнате %s",
+	HistoryMoreRounds:       "...и еще раундов: %d",
+	HistoryNobody:           "никто",
+	HistorySkipped:          "пропуск",
+	HistoryEliminated:       "\U0000274C выбыл",
+	HistoryBack:             "Назад",
+	ExportChooseFormat:      "Выберите формат файла с историей ваших игр",
+	ExportSending:           "Подготовка файла...",
+	ExportCaption:           "Ваша история игр, статистика и жесты",
+	VerifyOk:                "\U00002705 Обязательство соответствует раскрытому выбору",
+	VerifyFailed:            "\U0000274C Обязательство не соответствует раскрытому выбору (ожидалось <code>%s</code>)",
 	ErrorDetected: "<pre>Обнаружена ошибка</pre>\n" +
 		"<pre>%s</pre>\nЧто-то пошло не так",
 }